@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"math/big"
 	"mmm/token"
 	"strconv"
 	"strings"
@@ -10,6 +11,10 @@ import (
 
 type Node interface {
 	TokenLiteral() string
+	// Pos is the position of the first character belonging to the Node.
+	Pos() token.Pos
+	// End is the position of the first character immediately after the Node.
+	End() token.Pos
 	fmt.Stringer
 }
 
@@ -34,6 +39,20 @@ func (p Program) TokenLiteral() string {
 	return p.Statements[0].TokenLiteral()
 }
 
+func (p Program) Pos() token.Pos {
+	if len(p.Statements) == 0 {
+		return token.Pos{}
+	}
+	return p.Statements[0].Pos()
+}
+
+func (p Program) End() token.Pos {
+	if len(p.Statements) == 0 {
+		return token.Pos{}
+	}
+	return p.Statements[len(p.Statements)-1].End()
+}
+
 func (p Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -48,9 +67,9 @@ type LetStmt struct {
 	value Expr
 }
 
-func NewLetStmt(id Ident, value Expr) LetStmt {
+func NewLetStmt(t token.Token, id Ident, value Expr) LetStmt {
 	return LetStmt{
-		t:     token.New(token.TypeLookup, "let"),
+		t:     t,
 		name:  id,
 		value: value,
 	}
@@ -60,6 +79,8 @@ func (LetStmt) isStmt()                {}
 func (l LetStmt) TokenLiteral() string { return l.t.Literal() }
 func (l LetStmt) Name() string         { return l.name.value }
 func (l LetStmt) Value() Expr        { return l.value }
+func (l LetStmt) Pos() token.Pos       { return l.t.Pos() }
+func (l LetStmt) End() token.Pos       { return endOf(l.value, l.name.End()) }
 func (ls LetStmt) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -78,9 +99,9 @@ type RetStmt struct {
 	value Expr
 }
 
-func NewRetStmt(value Expr) RetStmt {
+func NewRetStmt(t token.Token, value Expr) RetStmt {
 	return RetStmt{
-		t:     token.New(token.TypeLookup, "return"),
+		t:     t,
 		value: value,
 	}
 }
@@ -88,6 +109,8 @@ func NewRetStmt(value Expr) RetStmt {
 func (RetStmt) isStmt()                {}
 func (rs RetStmt) TokenLiteral() string { return rs.t.Literal() }
 func (rs RetStmt) Value() Expr { return rs.value }
+func (rs RetStmt) Pos() token.Pos { return rs.t.Pos() }
+func (rs RetStmt) End() token.Pos { return endOf(rs.value, rs.t.End()) }
 func (rs RetStmt) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -119,6 +142,8 @@ func (es ExprStmt) TokenLiteral() string { return es.t.Literal() }
 func (es ExprStmt) Expression() Expr {
 	return es.value
 }
+func (es ExprStmt) Pos() token.Pos { return es.t.Pos() }
+func (es ExprStmt) End() token.Pos { return endOf(es.value, es.t.End()) }
 func (es ExprStmt) String() string {
 	if es.value == nil {
 		return ""
@@ -133,28 +158,57 @@ type Ident struct {
 	value string
 }
 
-func NewIdent(value string) Ident {
-	return Ident{t: token.New(token.TypeIdent, value), value: value}
+func NewIdent(t token.Token) Ident {
+	return Ident{t: t, value: t.Literal()}
 }
 
 func (Ident) isExpr()                {}
 func (i Ident) TokenLiteral() string { return i.t.Literal() }
+func (i Ident) Pos() token.Pos       { return i.t.Pos() }
+func (i Ident) End() token.Pos       { return i.t.End() }
 func (i Ident) String() string       { return i.value }
 
 type Integer struct {
 	t     token.Token
 	value int64
+	big   *big.Int
 }
 
-func NewInteger(v int64) Integer {
-	return Integer{t: token.New(token.TypeInt, strconv.Itoa(int(v))), value: v}
+func NewInteger(t token.Token, v int64) Integer {
+	return Integer{t: t, value: v}
+}
+
+// NewBigInteger is an Integer whose literal is too big for an int64.
+func NewBigInteger(t token.Token, v *big.Int) Integer {
+	return Integer{t: t, big: v}
 }
 
 func (Integer) isExpr()                {}
 func (i Integer) TokenLiteral() string { return i.t.Literal() }
+func (i Integer) Pos() token.Pos       { return i.t.Pos() }
+func (i Integer) End() token.Pos       { return i.t.End() }
 func (i Integer) Value() int64         { return i.value }
+
+// Big is the value of an Integer made with NewBigInteger, nil otherwise.
+func (i Integer) Big() *big.Int         { return i.big }
 func (i Integer) String() string       { return i.t.Literal() }
 
+type Float struct {
+	t     token.Token
+	value float64
+}
+
+func NewFloat(t token.Token, v float64) Float {
+	return Float{t: t, value: v}
+}
+
+func (Float) isExpr()                {}
+func (f Float) TokenLiteral() string { return f.t.Literal() }
+func (f Float) Pos() token.Pos       { return f.t.Pos() }
+func (f Float) End() token.Pos       { return f.t.End() }
+func (f Float) Value() float64       { return f.value }
+func (f Float) String() string       { return f.t.Literal() }
+
 type PrefixExpr struct {
 	t     token.Token
 	op    string
@@ -173,6 +227,8 @@ func (PrefixExpr) isExpr()                 {}
 func (pe PrefixExpr) TokenLiteral() string { return pe.t.Literal() }
 func (pe PrefixExpr) Operator() string     { return pe.op }
 func (pe PrefixExpr) Right() Expr          { return pe.right }
+func (pe PrefixExpr) Pos() token.Pos       { return pe.t.Pos() }
+func (pe PrefixExpr) End() token.Pos       { return endOf(pe.right, pe.t.End()) }
 func (pe PrefixExpr) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -203,6 +259,8 @@ func (ie InfixExpr) TokenLiteral() string { return ie.t.Literal() }
 func (ie InfixExpr) Left() Expr           { return ie.left }
 func (ie InfixExpr) Right() Expr          { return ie.right }
 func (ie InfixExpr) Operator() string     { return ie.op }
+func (ie InfixExpr) Pos() token.Pos       { return posOf(ie.left, ie.t.Pos()) }
+func (ie InfixExpr) End() token.Pos       { return endOf(ie.right, ie.t.End()) }
 func (ie InfixExpr) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -218,31 +276,38 @@ type Bool struct {
 	value bool
 }
 
-func NewBool(value bool) Bool {
-	return Bool{t: token.New(token.TypeLookup, strconv.FormatBool(value)), value: value}
+func NewBool(t token.Token, value bool) Bool {
+	return Bool{t: t, value: value}
 }
 
 func (Bool) isExpr()                {}
 func (b Bool) TokenLiteral() string { return b.t.Literal() }
+func (b Bool) Pos() token.Pos       { return b.t.Pos() }
+func (b Bool) End() token.Pos       { return b.t.End() }
 func (b Bool) String() string       { return b.t.Literal() }
 func (b Bool) Value() bool          { return b.value }
 
 type BlockStmt struct {
-	t          token.Token
+	t          token.Token // t is '{' token
 	Statements []Statement
 	valid      bool
+	// end is the position after the closing '}'.
+	end token.Pos
 }
 
-func NewBlockStmt(s []Statement) BlockStmt {
+func NewBlockStmt(lbrace token.Token, s []Statement, rbrace token.Token) BlockStmt {
 	return BlockStmt{
-		t:          token.New(token.TypeLBrace, "{"),
+		t:          lbrace,
 		Statements: s,
 		valid:      true,
+		end:        rbrace.End(),
 	}
 }
 
 func (BlockStmt) isStmt()                 {}
 func (bs BlockStmt) TokenLiteral() string { return bs.t.Literal() }
+func (bs BlockStmt) Pos() token.Pos       { return bs.t.Pos() }
+func (bs BlockStmt) End() token.Pos       { return bs.end }
 func (bs BlockStmt) OK() bool             { return bs.valid }
 func (bs BlockStmt) String() string {
 	var out bytes.Buffer
@@ -260,14 +325,14 @@ type IfExpr struct {
 }
 
 func NewIfExpr(
-	condition Expr, consequence BlockStmt, alternative *BlockStmt,
+	t token.Token, condition Expr, consequence BlockStmt, alternative *BlockStmt,
 ) IfExpr {
 	var alt BlockStmt
 	if alternative != nil {
 		alt = *alternative
 	}
 	return IfExpr{
-		t:           token.New(token.TypeLookup, "if"),
+		t:           t,
 		Condition:   condition,
 		Consequence: consequence,
 		Alternative: alt,
@@ -276,6 +341,13 @@ func NewIfExpr(
 
 func (IfExpr) isExpr()                 {}
 func (ie IfExpr) TokenLiteral() string { return ie.t.Literal() }
+func (ie IfExpr) Pos() token.Pos       { return ie.t.Pos() }
+func (ie IfExpr) End() token.Pos {
+	if ie.Alternative.valid {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie IfExpr) String() string {
 	var out bytes.Buffer
 
@@ -298,9 +370,9 @@ type Function struct {
 	Body   BlockStmt
 }
 
-func NewFunction(params []Ident, body BlockStmt) Function {
+func NewFunction(t token.Token, params []Ident, body BlockStmt) Function {
 	return Function{
-		t:      token.New(token.TypeLookup, "fn"),
+		t:      t,
 		Params: params,
 		Body:   body,
 	}
@@ -308,6 +380,8 @@ func NewFunction(params []Ident, body BlockStmt) Function {
 
 func (Function) isExpr()                {}
 func (f Function) TokenLiteral() string { return f.t.Literal() }
+func (f Function) Pos() token.Pos       { return f.t.Pos() }
+func (f Function) End() token.Pos       { return f.Body.End() }
 func (f Function) String() string {
 	var out bytes.Buffer
 
@@ -323,22 +397,53 @@ func (f Function) String() string {
 	return out.String()
 }
 
+// MacroLiteral is a macro definition, it's a Function whose arguments are
+// passed to it unevaluated as quoted ASTs, e.g.
+//
+//	let unless = macro(cond, then) { quote(if (!unquote(cond)) { unquote(then) }) };
+type MacroLiteral struct {
+	t      token.Token
+	Params []Ident
+	Body   BlockStmt
+}
+
+func NewMacroLiteral(t token.Token, params []Ident, body BlockStmt) MacroLiteral {
+	return MacroLiteral{t: t, Params: params, Body: body}
+}
+
+func (MacroLiteral) isExpr()                {}
+func (m MacroLiteral) TokenLiteral() string { return m.t.Literal() }
+func (m MacroLiteral) Pos() token.Pos       { return m.t.Pos() }
+func (m MacroLiteral) End() token.Pos       { return m.Body.End() }
+func (m MacroLiteral) String() string {
+	params := make([]string, len(m.Params))
+	for i, p := range m.Params {
+		params[i] = p.String()
+	}
+	return m.TokenLiteral() + "(" + strings.Join(params, ", ") + ") " + m.Body.String()
+}
+
 type CallExpr struct {
 	t    token.Token // t is '(' token
 	Fn   Expr
 	Args []Expr
+	// end is the position after the closing ')'.
+	end token.Pos
 }
 
-func NewCallExpr(fn Expr, args []Expr) CallExpr {
+func NewCallExpr(lparen token.Token, fn Expr, args []Expr, rparen token.Token) CallExpr {
 	return CallExpr{
-		t:    token.New(token.TypeLParen, "("),
+		t:    lparen,
 		Fn:   fn,
 		Args: args,
+		end:  rparen.End(),
 	}
 }
 
 func (CallExpr) isExpr()                 {}
 func (ce CallExpr) TokenLiteral() string { return ce.t.Literal() }
+func (ce CallExpr) Pos() token.Pos       { return posOf(ce.Fn, ce.t.Pos()) }
+func (ce CallExpr) End() token.Pos       { return ce.end }
 func (ce CallExpr) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -357,25 +462,31 @@ type String struct {
 	value string
 }
 
-func NewString(value string) String {
-	return String{t: token.New(token.TypeString, value), value: value}
+func NewString(t token.Token) String {
+	return String{t: t, value: t.Literal()}
 }
 
 func (String) isExpr() {}
 func (s String) TokenLiteral() string { return s.t.Literal() }
+func (s String) Pos() token.Pos { return s.t.Pos() }
+func (s String) End() token.Pos { return s.t.End() }
 func (s String) String() string { return s.value }
 
 type Slice struct {
-	t token.Token
+	t token.Token // t is '[' token
 	values []Expr
+	// end is the position after the closing ']'.
+	end token.Pos
 }
 
-func NewSlice(values ...Expr) Slice {
-	return Slice{t: token.New(token.TypeLBrakt, "["), values: values}
+func NewSlice(lbrakt, rbrakt token.Token, values ...Expr) Slice {
+	return Slice{t: lbrakt, values: values, end: rbrakt.End()}
 }
 
 func (Slice) isExpr() {}
 func (a Slice) TokenLiteral() string { return a.t.Literal() }
+func (a Slice) Pos() token.Pos { return a.t.Pos() }
+func (a Slice) End() token.Pos { return a.end }
 func (a Slice) Values() []Expr { return a.values }
 func (a Slice) String() string {
 	var out bytes.Buffer
@@ -390,17 +501,21 @@ func (a Slice) String() string {
 }
 
 type Index struct {
-	t token.Token
+	t token.Token // t is '[' token
 	left Expr
 	idx Expr
+	// end is the position after the closing ']'.
+	end token.Pos
 }
 
-func NewIndex(left, idx Expr) Index {
-	return Index{t: token.New(token.TypeLBrakt, "["), left: left, idx: idx}
+func NewIndex(lbrakt token.Token, left, idx Expr, rbrakt token.Token) Index {
+	return Index{t: lbrakt, left: left, idx: idx, end: rbrakt.End()}
 }
 
 func (Index) isExpr() {}
 func (i Index) TokenLiteral() string { return i.t.Literal() }
+func (i Index) Pos() token.Pos { return posOf(i.left, i.t.Pos()) }
+func (i Index) End() token.Pos { return i.end }
 func (i Index)	Left() Expr { return i.left }
 func (i Index)	Idx() Expr { return i.idx }
 func (i Index) String() string {
@@ -413,3 +528,202 @@ func (i Index) String() string {
 	return out.String()
 }
 
+// Pair is a single key and value of a Hash literal.
+type Pair struct {
+	Key   Expr
+	Value Expr
+}
+
+type Hash struct {
+	t     token.Token // t is '{' token
+	pairs []Pair
+	// end is the position after the closing '}'.
+	end token.Pos
+}
+
+func NewHash(lbrace, rbrace token.Token, pairs ...Pair) Hash {
+	return Hash{t: lbrace, pairs: pairs, end: rbrace.End()}
+}
+
+func (Hash) isExpr()                {}
+func (h Hash) TokenLiteral() string { return h.t.Literal() }
+func (h Hash) Pos() token.Pos       { return h.t.Pos() }
+func (h Hash) End() token.Pos       { return h.end }
+
+// Pairs are the keys and values in the order they were written.
+func (h Hash) Pairs() []Pair { return h.pairs }
+func (h Hash) String() string {
+	var out bytes.Buffer
+	pairs := make([]string, len(h.pairs))
+	for i, p := range h.pairs {
+		pairs[i] = p.Key.String() + ": " + p.Value.String()
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// AssignStmt changes an existing binding, or an element of a Slice or Hash,
+// e.g.
+//
+//	x = 1;
+//	xs[0] += 2;
+type AssignStmt struct {
+	t token.Token // t is the assignment operator
+	// Target is either an Ident or an Index.
+	Target Expr
+	Value  Expr
+}
+
+func NewAssignStmt(op token.Token, target, value Expr) AssignStmt {
+	return AssignStmt{t: op, Target: target, Value: value}
+}
+
+func (AssignStmt) isStmt()                {}
+func (a AssignStmt) TokenLiteral() string { return a.t.Literal() }
+
+// Operator is = or one of the compound operators like +=.
+func (a AssignStmt) Operator() string { return a.t.Literal() }
+func (a AssignStmt) Pos() token.Pos   { return posOf(a.Target, a.t.Pos()) }
+func (a AssignStmt) End() token.Pos   { return endOf(a.Value, a.t.End()) }
+func (a AssignStmt) String() string {
+	var out bytes.Buffer
+	out.WriteString(a.Target.String())
+	out.WriteString(" " + a.Operator() + " ")
+	if a.Value != nil {
+		out.WriteString(a.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+// WhileStmt repeats Body for as long as Cond is truthy, e.g.
+//
+//	while (x < 10) { ... }
+type WhileStmt struct {
+	t token.Token
+	// Label is the name given to the loop so that break and continue in nested
+	// loops can refer to it, it's empty when the loop isn't labelled.
+	Label string
+	Cond  Expr
+	Body  BlockStmt
+}
+
+func NewWhileStmt(t token.Token, label string, cond Expr, body BlockStmt) WhileStmt {
+	return WhileStmt{t: t, Label: label, Cond: cond, Body: body}
+}
+
+func (WhileStmt) isStmt()                {}
+func (w WhileStmt) TokenLiteral() string { return w.t.Literal() }
+func (w WhileStmt) Pos() token.Pos       { return w.t.Pos() }
+func (w WhileStmt) End() token.Pos       { return w.Body.End() }
+func (w WhileStmt) String() string {
+	var out bytes.Buffer
+	if w.Label != "" {
+		out.WriteString(w.Label + ": ")
+	}
+	out.WriteString("while")
+	out.WriteString(w.Cond.String())
+	out.WriteString(" ")
+	out.WriteString(w.Body.String())
+	return out.String()
+}
+
+// ForStmt runs Body once for every element of Iter with the element bound to
+// Var, e.g.
+//
+//	for (x in xs) { ... }
+type ForStmt struct {
+	t token.Token
+	// Label is the name given to the loop, see [WhileStmt].
+	Label string
+	Var   Ident
+	Iter  Expr
+	Body  BlockStmt
+}
+
+func NewForStmt(t token.Token, label string, v Ident, iter Expr, body BlockStmt) ForStmt {
+	return ForStmt{t: t, Label: label, Var: v, Iter: iter, Body: body}
+}
+
+func (ForStmt) isStmt()                {}
+func (f ForStmt) TokenLiteral() string { return f.t.Literal() }
+func (f ForStmt) Pos() token.Pos       { return f.t.Pos() }
+func (f ForStmt) End() token.Pos       { return f.Body.End() }
+func (f ForStmt) String() string {
+	var out bytes.Buffer
+	if f.Label != "" {
+		out.WriteString(f.Label + ": ")
+	}
+	out.WriteString("for (")
+	out.WriteString(f.Var.String())
+	out.WriteString(" in ")
+	out.WriteString(f.Iter.String())
+	out.WriteString(") ")
+	out.WriteString(f.Body.String())
+	return out.String()
+}
+
+// BranchStmt is a break or continue, optionally naming the label of the loop
+// it applies to.
+type BranchStmt struct {
+	t     token.Token
+	Label string
+	end   token.Pos
+}
+
+func NewBranchStmt(t token.Token, label string, end token.Pos) BranchStmt {
+	return BranchStmt{t: t, Label: label, end: end}
+}
+
+func (BranchStmt) isStmt()                {}
+func (b BranchStmt) TokenLiteral() string { return b.t.Literal() }
+func (b BranchStmt) Pos() token.Pos       { return b.t.Pos() }
+func (b BranchStmt) End() token.Pos       { return b.end }
+
+// Tok is either [token.TypeBreak] or [token.TypeContinue].
+func (b BranchStmt) Tok() token.Type { return b.t.Type() }
+func (b BranchStmt) String() string {
+	if b.Label == "" {
+		return b.t.Literal() + ";"
+	}
+	return b.t.Literal() + " " + b.Label + ";"
+}
+
+// Import evaluates to the namespace of the module at Path, e.g.
+//
+//	let lib = import "lib.mmm";
+type Import struct {
+	t    token.Token
+	path String
+}
+
+func NewImport(t token.Token, path String) Import {
+	return Import{t: t, path: path}
+}
+
+func (Import) isExpr()                {}
+func (i Import) TokenLiteral() string { return i.t.Literal() }
+func (i Import) Pos() token.Pos       { return i.t.Pos() }
+func (i Import) End() token.Pos       { return i.path.End() }
+
+// Path is the module as it was written, relative to the module importing it.
+func (i Import) Path() string   { return i.path.String() }
+func (i Import) String() string { return i.t.Literal() + " " + strconv.Quote(i.Path()) }
+
+// posOf is the start of n, or fallback when the parser couldn't produce n.
+func posOf(n Node, fallback token.Pos) token.Pos {
+	if n == nil {
+		return fallback
+	}
+	return n.Pos()
+}
+
+// endOf is the end of n, or fallback when the parser couldn't produce n.
+func endOf(n Node, fallback token.Pos) token.Pos {
+	if n == nil {
+		return fallback
+	}
+	return n.End()
+}
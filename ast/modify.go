@@ -0,0 +1,125 @@
+package ast
+
+// ModifierFunc is called by Modify with every Node of a tree and returns what
+// to replace it with, which is usually the Node itself.
+type ModifierFunc func(Node) Node
+
+// Modify walks the tree of node depth first, replacing every Node with the
+// result of calling modifier on it after its children have been modified.
+// The Nodes of the tree are copied rather than changed, so node itself is left
+// as it was.
+//
+// A Node that's replaced with something of the wrong kind, e.g. an Expr with
+// a Statement, is dropped from its parent.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case Program:
+		n.Statements = modifyStmts(n.Statements, modifier)
+		return modifier(n)
+	case ExprStmt:
+		n.value = modifyExpr(n.value, modifier)
+		return modifier(n)
+	case LetStmt:
+		n.value = modifyExpr(n.value, modifier)
+		return modifier(n)
+	case RetStmt:
+		n.value = modifyExpr(n.value, modifier)
+		return modifier(n)
+	case AssignStmt:
+		n.Target = modifyExpr(n.Target, modifier)
+		n.Value = modifyExpr(n.Value, modifier)
+		return modifier(n)
+	case BlockStmt:
+		return modifier(modifyBlock(n, modifier))
+	case WhileStmt:
+		n.Cond = modifyExpr(n.Cond, modifier)
+		n.Body = modifyBlock(n.Body, modifier)
+		return modifier(n)
+	case ForStmt:
+		n.Iter = modifyExpr(n.Iter, modifier)
+		n.Body = modifyBlock(n.Body, modifier)
+		return modifier(n)
+	case PrefixExpr:
+		n.right = modifyExpr(n.right, modifier)
+		return modifier(n)
+	case InfixExpr:
+		n.left = modifyExpr(n.left, modifier)
+		n.right = modifyExpr(n.right, modifier)
+		return modifier(n)
+	case IfExpr:
+		n.Condition = modifyExpr(n.Condition, modifier)
+		n.Consequence = modifyBlock(n.Consequence, modifier)
+		if n.Alternative.OK() {
+			n.Alternative = modifyBlock(n.Alternative, modifier)
+		}
+		return modifier(n)
+	case Function:
+		n.Body = modifyBlock(n.Body, modifier)
+		return modifier(n)
+	case MacroLiteral:
+		n.Body = modifyBlock(n.Body, modifier)
+		return modifier(n)
+	case CallExpr:
+		n.Fn = modifyExpr(n.Fn, modifier)
+		n.Args = modifyExprs(n.Args, modifier)
+		return modifier(n)
+	case Slice:
+		n.values = modifyExprs(n.values, modifier)
+		return modifier(n)
+	case Index:
+		n.left = modifyExpr(n.left, modifier)
+		n.idx = modifyExpr(n.idx, modifier)
+		return modifier(n)
+	case Hash:
+		pairs := make([]Pair, len(n.pairs))
+		for i, p := range n.pairs {
+			pairs[i] = Pair{
+				Key:   modifyExpr(p.Key, modifier),
+				Value: modifyExpr(p.Value, modifier),
+			}
+		}
+		n.pairs = pairs
+		return modifier(n)
+	default:
+		return modifier(node)
+	}
+}
+
+func modifyExpr(e Expr, modifier ModifierFunc) Expr {
+	if e == nil {
+		return nil
+	}
+	m, _ := Modify(e, modifier).(Expr)
+	return m
+}
+
+func modifyExprs(es []Expr, modifier ModifierFunc) []Expr {
+	if es == nil {
+		return nil
+	}
+	m := make([]Expr, len(es))
+	for i, e := range es {
+		m[i] = modifyExpr(e, modifier)
+	}
+	return m
+}
+
+func modifyStmts(ss []Statement, modifier ModifierFunc) []Statement {
+	if ss == nil {
+		return nil
+	}
+	m := make([]Statement, 0, len(ss))
+	for _, s := range ss {
+		if s, ok := Modify(s, modifier).(Statement); ok {
+			m = append(m, s)
+		}
+	}
+	return m
+}
+
+func modifyBlock(b BlockStmt, modifier ModifierFunc) BlockStmt {
+	b.Statements = modifyStmts(b.Statements, modifier)
+	return b
+}
@@ -0,0 +1,60 @@
+package ast_test
+
+import (
+	"testing"
+
+	"mmm/ast"
+	"mmm/is"
+	"mmm/lexer"
+	"mmm/parser"
+	"mmm/token"
+)
+
+func TestModify(t *testing.T) {
+	t.Parallel()
+	// oneToTwo replaces every 1 with a 2.
+	oneToTwo := func(n ast.Node) ast.Node {
+		if i, ok := n.(ast.Integer); ok && i.Value() == 1 {
+			return ast.NewInteger(token.New(token.TypeInt, "2"), 2)
+		}
+		return n
+	}
+	for name, tc := range map[string]struct {
+		input string
+		want  string
+	}{
+		"Integer":   {input: "1", want: "2"},
+		"Untouched": {input: "3", want: "3"},
+		"Infix":     {input: "1 + 1", want: "(2 + 2)"},
+		"Prefix":    {input: "-1", want: "(-2)"},
+		"Index":     {input: "1[1]", want: "(2[2])"},
+		"If":        {input: "if (1) { 1 } else { 1 }", want: "if2 2else 2"},
+		"Return":    {input: "fn() { return 1; }", want: "fn() return 2;"},
+		"Let":       {input: "let x = 1;", want: "let x = 2;"},
+		"Assign":    {input: "x[1] = 1;", want: "(x[2]) = 2;"},
+		"Slice":     {input: "[1, 1]", want: "[2, 2]"},
+		"Hash":      {input: "{1: 1}", want: "{2: 2}"},
+		"Call":      {input: "f(1, 3)", want: "f(2, 3)"},
+		"While":     {input: "while (1) { 1 }", want: "while2 2"},
+		"For":       {input: "for (x in 1) { 1 }", want: "for (x in 2) 2"},
+		"Macro":     {input: "macro(x) { 1 }", want: "macro(x) 2"},
+		"Many":      {input: "1; 3; 1", want: "232"},
+		"Nested":    {input: "f(g([1]))", want: "f(g([2]))"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p := parser.New(lexer.New(tc.input))
+			prg := p.Parse()
+			is.Equal(t, 0, len(p.Errors()))
+			got := ast.Modify(prg, oneToTwo)
+			is.Equal(t, tc.want, got.String())
+		})
+	}
+	t.Run("Original untouched", func(t *testing.T) {
+		t.Parallel()
+		prg := parser.New(lexer.New("[1, 1 + 1]")).Parse()
+		ast.Modify(prg, oneToTwo)
+		is.Equal(t, "[1, (1 + 1)]", prg.String())
+	})
+}
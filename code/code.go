@@ -1,10 +1,62 @@
 package code
 
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"mmm/token"
+)
+
+// Instructions are the bytecode the VM executes, a flat list of an Opcode
+// followed by its operands, over and over again.
 type Instructions []byte
 
-type Instruction struct {
-	op Opcode
-	operands [3]byte
+// String disassembles the Instructions, one instruction per line prefixed with
+// its offset e.g.
+//
+//	0000 OpConstant 65534
+//	0003 OpPop
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+	for i := 0; i < len(ins); {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+		if width := def.width(); i+width > len(ins) {
+			fmt.Fprintf(&out, "ERROR: %s at %04d wants %d bytes, has %d\n",
+				def.Name, i, width-1, len(ins)-i-1)
+			break
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, def.format(operands))
+		i += 1 + read
+	}
+	return out.String()
+}
+
+// Span is the source the instructions from Offset up to the Offset of the next
+// Span were compiled from.
+type Span struct {
+	Offset   int
+	Pos, End token.Pos
+}
+
+// Spans map the Instructions of a function back to its source, they're
+// ordered by Offset.
+type Spans []Span
+
+// Find returns the Span the instruction at offset was compiled from.
+func (s Spans) Find(offset int) (Span, bool) {
+	i := sort.Search(len(s), func(i int) bool { return s[i].Offset > offset })
+	if i == 0 {
+		return Span{}, false
+	}
+	return s[i-1], true
 }
 
 // Opcode is the first part of an Instruction that's used to tell the VM what to
@@ -15,8 +67,238 @@ const (
 	_ Opcode = iota
 	// OpConstant takes in 1 uint16 operand.
 	OpConstant
+	OpPop
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpTrue
+	OpFalse
+	OpNull
+	OpEqual
+	OpNotEqual
+	// OpGreaterThan is also used for less than by swapping the operands.
+	OpGreaterThan
+	OpMinus
+	OpBang
+	// OpJumpNotTruthy takes in 1 uint16 operand, the address to jump to.
+	OpJumpNotTruthy
+	// OpJump takes in 1 uint16 operand, the address to jump to.
+	OpJump
+	// OpGetGlobal takes in 1 uint16 operand, the index of the global.
+	OpGetGlobal
+	// OpSetGlobal takes in 1 uint16 operand, the index of the global.
+	OpSetGlobal
+	// OpGetLocal takes in 1 uint8 operand, the index of the local.
+	OpGetLocal
+	// OpSetLocal takes in 1 uint8 operand, the index of the local.
+	OpSetLocal
+	// OpGetBuiltin takes in 1 uint8 operand, the index of the builtin.
+	OpGetBuiltin
+	// OpGetFree takes in 1 uint8 operand, the index of the free variable.
+	OpGetFree
+	// OpSlice takes in 1 uint16 operand, the number of elements on the stack.
+	OpSlice
+	// OpHash takes in 1 uint16 operand, the number of keys and values on the
+	// stack, which is always twice the number of pairs.
+	OpHash
+	OpIndex
+	// OpCall takes in 1 uint8 operand, the number of arguments on the stack.
+	OpCall
+	OpReturnValue
+	OpReturn
+	// OpClosure takes in 2 operands, a uint16 constant index of the function
+	// and a uint8 number of free variables on the stack.
+	OpClosure
+	OpCurrentClosure
+	// OpIter replaces the value on top of the stack with the Slice of elements
+	// a for loop iterates over, followed by the Int index of the next element.
+	OpIter
+	// OpNext takes in 1 uint16 operand, the address to jump to once the
+	// iteration below it on the stack is done. Otherwise it pushes the next
+	// element and moves the index on.
+	OpNext
+	// OpDrop removes the top of the stack like OpPop but without it becoming
+	// the result of the program, it's for values the compiler put there itself.
+	OpDrop
+	// OpSetFree takes in 1 uint8 operand, the index of the free variable.
+	OpSetFree
+	// OpSetIndex takes in 1 uint8 operand, the Opcode of the binary operator
+	// of a compound assignment like xs[i] += 1, or 0 for a plain assignment.
+	OpSetIndex
+	// OpCaptureLocal takes in 1 uint8 operand, the index of the local. It's
+	// used instead of OpGetLocal to push a local for OpClosure so that the
+	// closure shares the local rather than having a copy of it.
+	OpCaptureLocal
+	// OpCaptureFree takes in 1 uint8 operand, the index of the free variable.
+	// It's OpCaptureLocal for a free variable captured again by a nested
+	// closure.
+	OpCaptureFree
+	// OpGreaterEqual is also used for less than or equal by swapping the
+	// operands.
+	OpGreaterEqual
+	OpMod
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	// OpImport takes in 2 uint16 operands, the index of the global holding an
+	// imported module and the address to jump to. When the module has already
+	// been imported it's pushed and the VM jumps, otherwise it carries on to
+	// the instructions that import it.
+	OpImport
+	// OpModule takes in 2 uint16 operands, the constant index of the name of
+	// the module and the number of names and values of its exports on the
+	// stack, which is always twice the number of exports. The values are
+	// pushed with OpCaptureLocal, so the exports that were never set can be
+	// left out.
+	OpModule
 )
 
-func Make(i Instruction) Instructions {
-	
+func (op Opcode) String() string {
+	def, err := Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("Opcode(%d)", byte(op))
+	}
+	return def.Name
+}
+
+// Definition describes an Opcode for humans and for decoding it.
+type Definition struct {
+	Name string
+	// OperandWidths is how many bytes each operand takes up.
+	OperandWidths []int
+}
+
+// Max is the largest value operand i can hold.
+func (def *Definition) Max(i int) int { return 1<<(8*def.OperandWidths[i]) - 1 }
+
+// width is how many bytes an instruction takes up, the Opcode included.
+func (def *Definition) width() int {
+	width := 1
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
 }
+
+func (def *Definition) format(operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d",
+			len(operands), len(def.OperandWidths))
+	}
+	out := def.Name
+	for _, o := range operands {
+		out += fmt.Sprintf(" %d", o)
+	}
+	return out
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant:       {Name: "OpConstant", OperandWidths: []int{2}},
+	OpPop:            {Name: "OpPop"},
+	OpAdd:            {Name: "OpAdd"},
+	OpSub:            {Name: "OpSub"},
+	OpMul:            {Name: "OpMul"},
+	OpDiv:            {Name: "OpDiv"},
+	OpTrue:           {Name: "OpTrue"},
+	OpFalse:          {Name: "OpFalse"},
+	OpNull:           {Name: "OpNull"},
+	OpEqual:          {Name: "OpEqual"},
+	OpNotEqual:       {Name: "OpNotEqual"},
+	OpGreaterThan:    {Name: "OpGreaterThan"},
+	OpMinus:          {Name: "OpMinus"},
+	OpBang:           {Name: "OpBang"},
+	OpJumpNotTruthy:  {Name: "OpJumpNotTruthy", OperandWidths: []int{2}},
+	OpJump:           {Name: "OpJump", OperandWidths: []int{2}},
+	OpGetGlobal:      {Name: "OpGetGlobal", OperandWidths: []int{2}},
+	OpSetGlobal:      {Name: "OpSetGlobal", OperandWidths: []int{2}},
+	OpGetLocal:       {Name: "OpGetLocal", OperandWidths: []int{1}},
+	OpSetLocal:       {Name: "OpSetLocal", OperandWidths: []int{1}},
+	OpGetBuiltin:     {Name: "OpGetBuiltin", OperandWidths: []int{1}},
+	OpGetFree:        {Name: "OpGetFree", OperandWidths: []int{1}},
+	OpSlice:          {Name: "OpSlice", OperandWidths: []int{2}},
+	OpHash:           {Name: "OpHash", OperandWidths: []int{2}},
+	OpIndex:          {Name: "OpIndex"},
+	OpCall:           {Name: "OpCall", OperandWidths: []int{1}},
+	OpReturnValue:    {Name: "OpReturnValue"},
+	OpReturn:         {Name: "OpReturn"},
+	OpClosure:        {Name: "OpClosure", OperandWidths: []int{2, 1}},
+	OpCurrentClosure: {Name: "OpCurrentClosure"},
+	OpIter:           {Name: "OpIter"},
+	OpNext:           {Name: "OpNext", OperandWidths: []int{2}},
+	OpDrop:           {Name: "OpDrop"},
+	OpSetFree:        {Name: "OpSetFree", OperandWidths: []int{1}},
+	OpSetIndex:       {Name: "OpSetIndex", OperandWidths: []int{1}},
+	OpCaptureLocal:   {Name: "OpCaptureLocal", OperandWidths: []int{1}},
+	OpCaptureFree:    {Name: "OpCaptureFree", OperandWidths: []int{1}},
+	OpGreaterEqual:   {Name: "OpGreaterEqual"},
+	OpMod:            {Name: "OpMod"},
+	OpBitAnd:         {Name: "OpBitAnd"},
+	OpBitOr:          {Name: "OpBitOr"},
+	OpBitXor:         {Name: "OpBitXor"},
+	OpShl:            {Name: "OpShl"},
+	OpShr:            {Name: "OpShr"},
+	OpImport:         {Name: "OpImport", OperandWidths: []int{2, 2}},
+	OpModule:         {Name: "OpModule", OperandWidths: []int{2, 2}},
+}
+
+// Lookup returns the Definition of the Opcode op.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes op and its operands into an instruction. Operands are stored
+// big endian, the ones op doesn't have are ignored and the missing ones are 0.
+// An undefined op makes empty Instructions.
+func Make(op Opcode, operands []int) Instructions {
+	def, ok := definitions[op]
+	if !ok {
+		return Instructions{}
+	}
+	ins := make(Instructions, def.width())
+	ins[0] = byte(op)
+	offset := 1
+	for i, w := range def.OperandWidths {
+		if i == len(operands) {
+			break
+		}
+		switch w {
+		case 2:
+			binary.BigEndian.PutUint16(ins[offset:], uint16(operands[i]))
+		case 1:
+			ins[offset] = byte(operands[i])
+		}
+		offset += w
+	}
+	return ins
+}
+
+// ReadOperands is the reverse of Make, it decodes the operands described by
+// def from the start of ins. It returns the operands and how many bytes were
+// read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+	for i, w := range def.OperandWidths {
+		switch w {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += w
+	}
+	return operands, offset
+}
+
+// ReadUint16 decodes a 2 byte operand from the start of ins.
+func ReadUint16(ins Instructions) uint16 { return binary.BigEndian.Uint16(ins) }
+
+// ReadUint8 decodes a 1 byte operand from the start of ins.
+func ReadUint8(ins Instructions) uint8 { return ins[0] }
@@ -3,6 +3,7 @@ package code_test
 import (
 	"mmm/code"
 	"mmm/is"
+	"mmm/token"
 	"testing"
 )
 
@@ -18,12 +19,127 @@ func TestMake(t *testing.T) {
 			operands: []int{0xFFFE},
 			want: code.Instructions{byte(code.OpConstant), 0xFF, 0xFE},
 		},
+		"No operands": {
+			op: code.OpAdd,
+			want: code.Instructions{byte(code.OpAdd)},
+		},
+		"Local": {
+			op: code.OpGetLocal,
+			operands: []int{255},
+			want: code.Instructions{byte(code.OpGetLocal), 0xFF},
+		},
+		"Closure": {
+			op: code.OpClosure,
+			operands: []int{0xFFFE, 255},
+			want: code.Instructions{byte(code.OpClosure), 0xFF, 0xFE, 0xFF},
+		},
+		"Extra operands": {
+			op: code.OpGetLocal,
+			operands: []int{1, 2},
+			want: code.Instructions{byte(code.OpGetLocal), 1},
+		},
+		"Missing operand": {
+			op: code.OpClosure,
+			operands: []int{1},
+			want: code.Instructions{byte(code.OpClosure), 0, 1, 0},
+		},
+		"Undefined": {
+			op: 0,
+			operands: []int{1},
+			want: code.Instructions{},
+		},
 	} {
 		tc := tc
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 			got := code.Make(tc.op, tc.operands)
 			is.Equal(t, len(tc.want), len(got))
+			for i, b := range tc.want {
+				is.Equal(t, b, got[i])
+			}
+		})
+	}
+}
+
+func TestInstructions_String(t *testing.T) {
+	t.Parallel()
+	ins := code.Instructions{}
+	for _, i := range []code.Instructions{
+		code.Make(code.OpAdd, nil),
+		code.Make(code.OpGetLocal, []int{1}),
+		code.Make(code.OpConstant, []int{2}),
+		code.Make(code.OpConstant, []int{65534}),
+		code.Make(code.OpClosure, []int{65535, 255}),
+	} {
+		ins = append(ins, i...)
+	}
+	is.Equal(t, `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65534
+0009 OpClosure 65535 255
+`, ins.String())
+	is.Equal(t, "ERROR: opcode 0 undefined\n", code.Instructions{0}.String())
+	is.Equal(t, "ERROR: OpConstant at 0000 wants 2 bytes, has 1\n",
+		code.Instructions{byte(code.OpConstant), 1}.String())
+	is.Equal(t, "0000 OpPop\nERROR: OpClosure at 0001 wants 3 bytes, has 0\n",
+		code.Instructions{byte(code.OpPop), byte(code.OpClosure)}.String())
+}
+
+func TestReadOperands(t *testing.T) {
+	t.Parallel()
+	for name, tc := range map[string]struct {
+		op       code.Opcode
+		operands []int
+		read     int
+	}{
+		"uint16":     {op: code.OpConstant, operands: []int{65535}, read: 2},
+		"uint8":      {op: code.OpGetLocal, operands: []int{255}, read: 1},
+		"Two":        {op: code.OpClosure, operands: []int{65535, 255}, read: 3},
+		"No operand": {op: code.OpPop, operands: []int{}, read: 0},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			def, err := code.Lookup(byte(tc.op))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, read := code.ReadOperands(def, code.Make(tc.op, tc.operands)[1:])
+			is.Equal(t, tc.read, read)
+			is.Equal(t, len(tc.operands), len(got))
+			for i, want := range tc.operands {
+				is.Equal(t, want, got[i])
+			}
+		})
+	}
+}
+
+func TestSpans_Find(t *testing.T) {
+	t.Parallel()
+	spans := code.Spans{
+		{Offset: 0, Pos: token.Pos{Line: 1, Col: 1}},
+		{Offset: 3, Pos: token.Pos{Line: 1, Col: 5}},
+		{Offset: 7, Pos: token.Pos{Line: 2, Col: 1}},
+	}
+	for name, tc := range map[string]struct {
+		spans  code.Spans
+		offset int
+		want   string
+		ok     bool
+	}{
+		"First":    {spans: spans, offset: 0, want: "1:1", ok: true},
+		"Inside":   {spans: spans, offset: 5, want: "1:5", ok: true},
+		"Start":    {spans: spans, offset: 7, want: "2:1", ok: true},
+		"Past end": {spans: spans, offset: 100, want: "2:1", ok: true},
+		"Empty":    {offset: 0, want: "-"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			span, ok := tc.spans.Find(tc.offset)
+			is.Equal(t, tc.ok, ok)
+			is.Equal(t, tc.want, span.Pos.String())
 		})
 	}
 }
@@ -0,0 +1,821 @@
+// package compiler turns the [ast.Node]s produced by the parser package into
+// [code.Instructions] and a pool of constants that the vm package can execute.
+// It's the bytecode counterpart to the eval package and any program should
+// produce the same result through either of them.
+package compiler
+
+import (
+	"fmt"
+
+	"mmm/ast"
+	"mmm/code"
+	"mmm/entity"
+	"mmm/eval"
+	"mmm/module"
+	"mmm/token"
+)
+
+// Bytecode is everything the VM needs to run a compiled program.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []entity.E
+	// Globals are the names of the global bindings by index.
+	Globals []string
+	// Spans are where the Instructions came from.
+	Spans code.Spans
+}
+
+// emitted is an instruction the compiler has already written.
+type emitted struct {
+	op  code.Opcode
+	pos int
+}
+
+// scope is the instructions of a single function being compiled, the
+// outermost scope being the main program.
+type scope struct {
+	instructions code.Instructions
+	last         emitted
+	prev         emitted
+	// spans are the nodes the instructions were emitted for.
+	spans code.Spans
+	// loops are the loops being compiled in this scope, innermost last.
+	loops []*loop
+	// module is set for the scope of the top level of an imported module,
+	// where a return jumps to the end of the module to build its exports.
+	module bool
+	// returns are the positions of the jumps emitted for those returns.
+	returns []int
+	// pending is how many values are on the stack waiting for the expression
+	// being compiled, like the left operand of an infix while the right one is
+	// compiled, or the elements and index of a for loop.
+	pending int
+}
+
+// loop is what the break and continue statements inside a loop need to know
+// about it.
+type loop struct {
+	label string
+	// next is the address continue jumps to.
+	next int
+	// breaks are the positions of the jumps emitted for break, they're changed
+	// to the end of the loop once it's known.
+	breaks []int
+	// pending is the pending of the scope in the body of the loop, anything
+	// above it is dropped by break and continue.
+	pending int
+}
+
+// Compiler walks an AST emitting bytecode.
+type Compiler struct {
+	constants []entity.E
+	symbols   *SymbolTable
+	scopes    []scope
+	// globals is the global SymbolTable, which holds the imported modules even
+	// while the symbols of a module are being compiled.
+	globals *SymbolTable
+	// top is the outermost SymbolTable of the program or module being
+	// compiled, where the names used before they're defined are defined.
+	top *SymbolTable
+
+	loader module.Loader
+	// path is the name of the module being compiled.
+	path string
+	// loading are the modules being compiled, the outermost first.
+	loading []string
+	// overflow is what there's too many of for an operand emitted since the
+	// last node was compiled, see operandNames.
+	overflow string
+	// node is the innermost node being compiled, the instructions emitted are
+	// attributed to it in the spans of the scope.
+	node ast.Node
+}
+
+// New returns a Compiler with an empty constant pool and global scope.
+func New() *Compiler {
+	return NewWithState(builtinSymbols(), nil)
+}
+
+// builtinSymbols is a global SymbolTable with only the builtins defined.
+func builtinSymbols() *SymbolTable {
+	s := NewSymbolTable()
+	for i, b := range entity.Builtins {
+		s.DefineBuiltin(i, b.Name)
+	}
+	return s
+}
+
+// NewWithState returns a Compiler that continues from the symbols and
+// constants of a previous compilation, this is how the REPL keeps its globals
+// between lines.
+func NewWithState(s *SymbolTable, constants []entity.E) *Compiler {
+	return &Compiler{
+		constants: constants,
+		symbols:   s,
+		scopes:    []scope{{}},
+		globals:   s,
+		top:       s,
+	}
+}
+
+// SetLoader enables imports, the modules imported by the program called main
+// are compiled from the source l loads. main is empty when the program isn't
+// a module itself, e.g. the input of the REPL.
+func (c *Compiler) SetLoader(l module.Loader, main string) {
+	c.loader, c.path, c.loading = l, main, nil
+	if main != "" {
+		c.loading = []string{main}
+	}
+}
+
+// SetHost makes the functions and constants of h available to the programs
+// compiled, and the modules they import, as constants.
+func (c *Compiler) SetHost(h *entity.Host) {
+	for _, n := range h.Names() {
+		v, _ := h.Get(n)
+		c.globals.DefineHost(c.addConstant(v), n)
+	}
+}
+
+// SymbolTable is the global SymbolTable the Compiler defines into.
+func (c *Compiler) SymbolTable() *SymbolTable { return c.symbols }
+
+// Bytecode is the result of everything compiled so far.
+func (c *Compiler) Bytecode() Bytecode {
+	return Bytecode{
+		Instructions: c.instructions(),
+		Constants:    c.constants,
+		Globals:      c.globals.names(ScopeGlobal),
+		Spans:        c.scope().spans,
+	}
+}
+
+// Compile emits the bytecode for node.
+func (c *Compiler) Compile(node ast.Node) error {
+	outer := c.node
+	c.node = node
+	defer func() { c.node = outer }()
+	if err := c.compile(node); err != nil {
+		return err
+	}
+	if c.overflow != "" {
+		err := errorf(node, "too many %s", c.overflow)
+		c.overflow = ""
+		return err
+	}
+	return nil
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	switch node := node.(type) {
+	// Statements
+	case ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case ast.ExprStmt:
+		if err := c.Compile(node.Expression()); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case ast.BlockStmt:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+	case ast.LetStmt:
+		var sym Symbol
+		if fn, ok := node.Value().(ast.Function); ok {
+			// Functions are defined first so they can refer to themselves.
+			sym = c.symbols.Define(node.Name())
+			if err := c.compileFn(fn, node.Name()); err != nil {
+				return err
+			}
+		} else {
+			if err := c.Compile(node.Value()); err != nil {
+				return err
+			}
+			sym = c.symbols.Define(node.Name())
+		}
+		c.store(sym)
+	case ast.AssignStmt:
+		return c.compileAssign(node)
+	case ast.WhileStmt:
+		return c.compileWhile(node)
+	case ast.ForStmt:
+		return c.compileFor(node)
+	case ast.BranchStmt:
+		return c.compileBranch(node)
+	case ast.RetStmt:
+		if err := c.Compile(node.Value()); err != nil {
+			return err
+		}
+		if s := c.scope(); s.module {
+			c.emit(code.OpDrop)
+			s.returns = append(s.returns, c.emit(code.OpJump, 0xFFFF))
+			break
+		}
+		c.emit(code.OpReturnValue)
+	// Expressions
+	case ast.Integer:
+		if v := node.Big(); v != nil {
+			c.emit(code.OpConstant, c.addConstant(entity.BigInt{Value: v}))
+			return nil
+		}
+		c.emit(code.OpConstant, c.addConstant(entity.Int{Value: node.Value()}))
+	case ast.Float:
+		c.emit(code.OpConstant, c.addConstant(entity.Float{Value: node.Value()}))
+	case ast.String:
+		c.emit(code.OpConstant, c.addConstant(entity.String{Value: node.String()}))
+	case ast.Bool:
+		if node.Value() {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case ast.PrefixExpr:
+		if err := c.Compile(node.Right()); err != nil {
+			return err
+		}
+		switch node.Operator() {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return errorf(node, "unknown operator %s", node.Operator())
+		}
+	case ast.InfixExpr:
+		return c.compileInfix(node)
+	case ast.IfExpr:
+		return c.compileIf(node)
+	case ast.Ident:
+		sym, ok := c.symbols.Resolve(node.String())
+		if !ok {
+			// It's defined at the top for when it's defined there later, like
+			// a function calling one that comes after it. Loading it fails
+			// like it does in the eval package until it's been set.
+			c.top.Define(node.String())
+			sym, _ = c.symbols.Resolve(node.String())
+		}
+		c.load(sym)
+	case ast.Slice:
+		if err := c.compileOperands(node.Values()...); err != nil {
+			return err
+		}
+		c.emit(code.OpSlice, len(node.Values()))
+	case ast.Hash:
+		var kvs []ast.Expr
+		for _, p := range node.Pairs() {
+			kvs = append(kvs, p.Key, p.Value)
+		}
+		if err := c.compileOperands(kvs...); err != nil {
+			return err
+		}
+		c.emit(code.OpHash, len(node.Pairs())*2)
+	case ast.Index:
+		if err := c.compileOperands(node.Left(), node.Idx()); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case ast.Function:
+		return c.compileFn(node, "")
+	case ast.CallExpr:
+		if err := c.compileOperands(append([]ast.Expr{node.Fn}, node.Args...)...); err != nil {
+			return err
+		}
+		c.emit(code.OpCall, len(node.Args))
+	case ast.Import:
+		return c.compileImport(node)
+	case nil:
+		return fmt.Errorf("cannot compile a missing node")
+	default:
+		return errorf(node, "cannot compile %T", node)
+	}
+	return nil
+}
+
+// binaryOps are the Opcodes of the infix operators that evaluate both of their
+// operands, with < and <= done as > and >= with the operands swapped.
+var binaryOps = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	">":  code.OpGreaterThan,
+	"<":  code.OpGreaterThan,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpGreaterEqual,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShl,
+	">>": code.OpShr,
+}
+
+func (c *Compiler) compileInfix(node ast.InfixExpr) error {
+	if op := node.Operator(); op == "&&" || op == "||" {
+		return c.compileLogical(node)
+	}
+	op, ok := binaryOps[node.Operator()]
+	if !ok {
+		return errorf(node, "unknown operator %s", node.Operator())
+	}
+	left, right := node.Left(), node.Right()
+	if node.Operator() == "<" || node.Operator() == "<=" {
+		// There's no OpLessThan, a < b is the same as b > a.
+		left, right = right, left
+	}
+	if err := c.compileOperands(left, right); err != nil {
+		return err
+	}
+	c.emit(op)
+	return nil
+}
+
+// compileOperands compiles exprs one after the other, each one leaving its
+// value on the stack for the instruction that follows them.
+func (c *Compiler) compileOperands(exprs ...ast.Expr) error {
+	defer func(pending int) { c.scope().pending = pending }(c.scope().pending)
+	for _, e := range exprs {
+		if err := c.Compile(e); err != nil {
+			return err
+		}
+		c.scope().pending++
+	}
+	return nil
+}
+
+// compileLogical compiles && and || so that the right operand is only run when
+// the left one doesn't decide the result. a || b is compiled as !(!a && !b).
+func (c *Compiler) compileLogical(node ast.InfixExpr) error {
+	or := node.Operator() == "||"
+	var jumps []int
+	for _, e := range []ast.Expr{node.Left(), node.Right()} {
+		if err := c.Compile(e); err != nil {
+			return err
+		}
+		if or {
+			c.emit(code.OpBang)
+		}
+		jumps = append(jumps, c.emit(code.OpJumpNotTruthy, 0xFFFF))
+	}
+	whole, short := code.OpTrue, code.OpFalse
+	if or {
+		whole, short = short, whole
+	}
+	c.emit(whole)
+	end := c.emit(code.OpJump, 0xFFFF)
+	for _, j := range jumps {
+		c.changeOperand(j, len(c.instructions()))
+	}
+	c.emit(short)
+	c.changeOperand(end, len(c.instructions()))
+	return nil
+}
+
+func (c *Compiler) compileIf(node ast.IfExpr) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	// The real addresses are only known after compiling the blocks, so jumps
+	// are emitted with a bogus address and changed afterwards.
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 0xFFFF)
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+	jump := c.emit(code.OpJump, 0xFFFF)
+	c.changeOperand(jumpNotTruthy, len(c.instructions()))
+	if node.Alternative.OK() {
+		if err := c.compileBlockValue(node.Alternative); err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpNull)
+	}
+	c.changeOperand(jump, len(c.instructions()))
+	return nil
+}
+
+// assignOps are the binary Opcodes of the compound assignment operators.
+var assignOps = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+func (c *Compiler) compileAssign(node ast.AssignStmt) error {
+	op, compound := assignOps[node.Operator()]
+	switch target := node.Target.(type) {
+	case ast.Ident:
+		sym, ok := c.symbols.Resolve(target.String())
+		if !ok || sym.Scope == ScopeBuiltin || sym.Scope == ScopeHost {
+			return errorf(node, "assignment to undeclared identifier: %s", target)
+		}
+		if compound {
+			c.load(sym)
+			c.scope().pending++
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.scope().pending--
+			c.emit(op)
+		}
+		c.store(sym)
+	case ast.Index:
+		if err := c.compileOperands(target.Left(), target.Idx(), node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex, int(op))
+	default:
+		return errorf(node, "cannot assign to %s", node.Target)
+	}
+	return nil
+}
+
+func (c *Compiler) compileWhile(node ast.WhileStmt) error {
+	start := len(c.instructions())
+	if err := c.Compile(node.Cond); err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 0xFFFF)
+	l := c.enterLoop(node.Label, start)
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+	c.changeOperand(jumpNotTruthy, len(c.instructions()))
+	c.leaveLoop(l)
+	return nil
+}
+
+// compileFor keeps the elements being iterated over and the index of the next
+// one on the stack for the whole loop, OpNext jumps to the OpDrop that removes
+// them once there are no more elements.
+func (c *Compiler) compileFor(node ast.ForStmt) error {
+	if err := c.Compile(node.Iter); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	next := c.emit(code.OpNext, 0xFFFF)
+	c.store(c.symbols.Define(node.Var.String()))
+	c.scope().pending += 2
+	l := c.enterLoop(node.Label, next)
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.scope().pending -= 2
+	c.emit(code.OpJump, next)
+	c.changeOperand(next, len(c.instructions()))
+	c.leaveLoop(l)
+	c.emit(code.OpDrop)
+	c.emit(code.OpDrop)
+	return nil
+}
+
+func (c *Compiler) compileBranch(node ast.BranchStmt) error {
+	loops := c.scope().loops
+	for i := len(loops) - 1; i >= 0; i-- {
+		l := loops[i]
+		if node.Label != "" && node.Label != l.label {
+			continue
+		}
+		// Leaving the expressions and inner for loops the branch is in has to
+		// clean up after them.
+		for n := c.scope().pending; n > l.pending; n-- {
+			c.emit(code.OpDrop)
+		}
+		if node.Tok() == token.TypeContinue {
+			c.emit(code.OpJump, l.next)
+		} else {
+			l.breaks = append(l.breaks, c.emit(code.OpJump, 0xFFFF))
+		}
+		return nil
+	}
+	return errorf(node, "%s is not in a loop", node.TokenLiteral())
+}
+
+func (c *Compiler) enterLoop(label string, next int) *loop {
+	s := c.scope()
+	l := &loop{label: label, next: next, pending: s.pending}
+	s.loops = append(s.loops, l)
+	return l
+}
+
+// leaveLoop points the breaks of l at the current end of the instructions.
+func (c *Compiler) leaveLoop(l *loop) {
+	for _, b := range l.breaks {
+		c.changeOperand(b, len(c.instructions()))
+	}
+	s := c.scope()
+	s.loops = s.loops[:len(s.loops)-1]
+}
+
+// compileBlockValue compiles a block that's used as an expression, leaving
+// the value of its last expression on the stack, or null if it has none.
+func (c *Compiler) compileBlockValue(blk ast.BlockStmt) error {
+	if err := c.Compile(blk); err != nil {
+		return err
+	}
+	switch c.scope().last.op {
+	case code.OpPop:
+		c.removeLastPop()
+	case code.OpReturnValue:
+	default:
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileFn compiles fn into a constant and emits the closure that wraps it.
+// name is what fn was bound to with let, if anything. A function that assigns
+// to name refers to it through the binding instead of as itself, so that it
+// sees what it assigned.
+func (c *Compiler) compileFn(fn ast.Function, name string) error {
+	c.enterScope()
+	if name != "" && !assigns(fn.Body, name) {
+		c.symbols.DefineFunctionName(name)
+	}
+	for _, p := range fn.Params {
+		c.symbols.Define(p.String())
+	}
+	if err := c.Compile(fn.Body); err != nil {
+		return err
+	}
+	switch c.scope().last.op {
+	case code.OpPop:
+		c.replaceLastPopWithReturn()
+	case code.OpReturnValue:
+	default:
+		c.emit(code.OpReturn)
+	}
+	free, numLocals, locals := c.symbols.Free, c.symbols.defs, c.symbols.names(ScopeLocal)
+	spans := c.scope().spans
+	ins := c.leaveScope()
+	names := make([]string, len(free))
+	for i, s := range free {
+		c.capture(s)
+		names[i] = s.Name
+	}
+	compiled := entity.CompiledFn{
+		Name:         name,
+		Instructions: ins,
+		NumLocals:    numLocals,
+		NumParams:    len(fn.Params),
+		Locals:       locals,
+		Free:         names,
+		Spans:        spans,
+	}
+	c.emit(code.OpClosure, c.addConstant(compiled), len(free))
+	return nil
+}
+
+// assigns reports whether anything in node assigns to name.
+func assigns(node ast.Node, name string) bool {
+	found := false
+	ast.Modify(node, func(n ast.Node) ast.Node {
+		if a, ok := n.(ast.AssignStmt); ok {
+			if id, ok := a.Target.(ast.Ident); ok && id.String() == name {
+				found = true
+			}
+		}
+		return n
+	})
+	return found
+}
+
+// compileImport emits the instructions that evaluate the module imported by
+// imp the first time they're run, and push the Module it produced every time.
+func (c *Compiler) compileImport(imp ast.Import) error {
+	if c.loader == nil {
+		return errorf(imp, "cannot import %q: imports are not enabled", imp.Path())
+	}
+	name, err := module.Resolve(c.path, imp.Path())
+	if err != nil {
+		return errorf(imp, "%s", err)
+	}
+	m, ok := c.globals.imports[name]
+	if !ok {
+		fn, err := c.compileModule(imp, name)
+		if err != nil {
+			return err
+		}
+		m = c.globals.defineImport(name, fn)
+	}
+	pos := c.emit(code.OpImport, m.global, 0xFFFF)
+	c.emit(code.OpClosure, m.fn, 0)
+	c.emit(code.OpCall, 0)
+	c.emit(code.OpSetGlobal, m.global)
+	c.emit(code.OpGetGlobal, m.global)
+	copy(c.instructions()[pos:], code.Make(code.OpImport, []int{m.global, len(c.instructions())}))
+	return nil
+}
+
+// compileModule compiles the module called name into a constant function that
+// takes no arguments and returns the Module, it returns the constant's index.
+// The module only sees the builtins, the Host and the bindings it makes itself.
+func (c *Compiler) compileModule(imp ast.Import, name string) (int, error) {
+	if err := module.Cycle(c.loading, name); err != nil {
+		return 0, errorf(imp, "%s", err)
+	}
+	prg, err := module.Load(c.loader, name)
+	if err != nil {
+		return 0, errorf(imp, "%s", err)
+	}
+	macros := entity.NewEnv()
+	if prg, err = eval.ExpandMacros(eval.DefineMacros(prg, macros), macros); err != nil {
+		return 0, err
+	}
+	symbols, top, path, loading := c.symbols, c.top, c.path, c.loading
+	defer func() { c.symbols, c.top, c.path, c.loading = symbols, top, path, loading }()
+	outer := builtinSymbols()
+	for _, sym := range c.globals.host {
+		outer.DefineHost(sym.Index, sym.Name)
+	}
+	c.symbols = NewEnclosedSymbolTable(outer)
+	c.top = c.symbols
+	c.path, c.loading = name, append(loading[:len(loading):len(loading)], name)
+	c.scopes = append(c.scopes, scope{module: true})
+	defer func() { c.scopes = c.scopes[:len(c.scopes)-1] }()
+	if err := c.Compile(prg); err != nil {
+		return 0, err
+	}
+	for _, r := range c.scope().returns {
+		c.changeOperand(r, len(c.instructions()))
+	}
+	exports := 0
+	for _, sym := range c.symbols.Symbols() {
+		if sym.Scope == ScopeLocal && module.Exported(sym.Name) {
+			c.emit(code.OpConstant, c.addConstant(entity.String{Value: sym.Name}))
+			// Captured rather than loaded, which would fail for a local that
+			// was never set.
+			c.emit(code.OpCaptureLocal, sym.Index)
+			exports++
+		}
+	}
+	c.emit(code.OpModule, c.addConstant(entity.String{Value: name}), exports*2)
+	c.emit(code.OpReturnValue)
+	return c.addConstant(entity.CompiledFn{
+		Name:         imp.String(),
+		Instructions: c.instructions(),
+		NumLocals:    c.symbols.defs,
+		Locals:       c.symbols.names(ScopeLocal),
+		Spans:        c.scope().spans,
+	}), nil
+}
+
+func (c *Compiler) load(s Symbol) {
+	switch s.Scope {
+	case ScopeGlobal:
+		c.emit(code.OpGetGlobal, s.Index)
+	case ScopeLocal:
+		c.emit(code.OpGetLocal, s.Index)
+	case ScopeBuiltin:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case ScopeFree:
+		c.emit(code.OpGetFree, s.Index)
+	case ScopeFunction:
+		c.emit(code.OpCurrentClosure)
+	case ScopeHost:
+		c.emit(code.OpConstant, s.Index)
+	}
+}
+
+func (c *Compiler) store(s Symbol) {
+	switch s.Scope {
+	case ScopeGlobal:
+		c.emit(code.OpSetGlobal, s.Index)
+	case ScopeLocal:
+		c.emit(code.OpSetLocal, s.Index)
+	case ScopeFree:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// capture pushes s for OpClosure. Locals and free variables are shared with
+// the closure so that assigning to them on either side is seen by both.
+func (c *Compiler) capture(s Symbol) {
+	switch s.Scope {
+	case ScopeLocal:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case ScopeFree:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.load(s)
+	}
+}
+
+func (c *Compiler) addConstant(e entity.E) int {
+	c.constants = append(c.constants, e)
+	return len(c.constants) - 1
+}
+
+// operandNames are what the operands of the opcodes count, used in the error
+// for a program that has more of them than an operand can hold.
+var operandNames = map[code.Opcode][]string{
+	code.OpConstant:      {"constants"},
+	code.OpJump:          {"instructions"},
+	code.OpJumpNotTruthy: {"instructions"},
+	code.OpNext:          {"instructions"},
+	code.OpGetGlobal:     {"global bindings"},
+	code.OpSetGlobal:     {"global bindings"},
+	code.OpGetLocal:      {"local bindings"},
+	code.OpSetLocal:      {"local bindings"},
+	code.OpCaptureLocal:  {"local bindings"},
+	code.OpGetFree:       {"free variables"},
+	code.OpSetFree:       {"free variables"},
+	code.OpCaptureFree:   {"free variables"},
+	code.OpClosure:       {"constants", "free variables"},
+	code.OpSlice:         {"elements"},
+	code.OpHash:          {"elements"},
+	code.OpCall:          {"arguments"},
+	code.OpImport:        {"global bindings", "instructions"},
+	code.OpModule:        {"constants", "exports"},
+}
+
+// checkOperands records in overflow the first of operands that's too big for
+// op, which would otherwise be silently truncated.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.overflow != "" {
+		return
+	}
+	for i, o := range operands {
+		if o > def.Max(i) {
+			c.overflow = "operands"
+			if names := operandNames[op]; i < len(names) {
+				c.overflow = names[i]
+			}
+			return
+		}
+	}
+}
+
+// emit writes an instruction to the current scope and returns its position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	s := c.scope()
+	pos := len(s.instructions)
+	s.instructions = append(s.instructions, code.Make(op, operands)...)
+	s.prev, s.last = s.last, emitted{op: op, pos: pos}
+	if c.node != nil {
+		span := code.Span{Offset: pos, Pos: c.node.Pos(), End: c.node.End()}
+		if n := len(s.spans); n == 0 || s.spans[n-1].Pos != span.Pos || s.spans[n-1].End != span.End {
+			s.spans = append(s.spans, span)
+		}
+	}
+	return pos
+}
+
+func (c *Compiler) scope() *scope { return &c.scopes[len(c.scopes)-1] }
+
+func (c *Compiler) instructions() code.Instructions {
+	return c.scope().instructions
+}
+
+func (c *Compiler) removeLastPop() {
+	s := c.scope()
+	s.instructions = s.instructions[:s.last.pos]
+	s.last = s.prev
+	for n := len(s.spans); n > 0 && s.spans[n-1].Offset >= len(s.instructions); n-- {
+		s.spans = s.spans[:n-1]
+	}
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	s := c.scope()
+	copy(s.instructions[s.last.pos:], code.Make(code.OpReturnValue, nil))
+	s.last.op = code.OpReturnValue
+}
+
+// changeOperand rewrites the operand of the instruction at pos.
+func (c *Compiler) changeOperand(pos, operand int) {
+	ins := c.instructions()
+	c.checkOperands(code.Opcode(ins[pos]), []int{operand})
+	copy(ins[pos:], code.Make(code.Opcode(ins[pos]), []int{operand}))
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, scope{})
+	c.symbols = NewEnclosedSymbolTable(c.symbols)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	ins := c.instructions()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.symbols = c.symbols.outer
+	return ins
+}
+
+func errorf(node ast.Node, format string, a ...any) error {
+	return fmt.Errorf("%s: "+format, append([]any{node.Pos()}, a...)...)
+}
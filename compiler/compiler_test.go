@@ -0,0 +1,742 @@
+package compiler_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"mmm/code"
+	"mmm/compiler"
+	"mmm/entity"
+	"mmm/is"
+	"mmm/lexer"
+	"mmm/module"
+	"mmm/parser"
+)
+
+type testCase struct {
+	input string
+	// constants are int64, string or []code.Instructions for a function.
+	constants []any
+	want      []code.Instructions
+}
+
+func TestCompiler_Compile(t *testing.T) {
+	t.Parallel()
+	t.Run("Integers", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"One digit": {
+				input:     "5",
+				constants: []any{int64(5)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Negative": {
+				input:     "-5",
+				constants: []any{int64(5)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpMinus, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Two statements": {
+				input:     "1; 2",
+				constants: []any{int64(1), int64(2)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpPop, nil),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Infix Integer Expressions", func(t *testing.T) {
+		t.Parallel()
+		cases := map[string]testCase{}
+		for name, op := range map[string]code.Opcode{
+			"+": code.OpAdd, "-": code.OpSub, "*": code.OpMul, "/": code.OpDiv,
+			">": code.OpGreaterThan, "==": code.OpEqual, "!=": code.OpNotEqual,
+			">=": code.OpGreaterEqual, "%": code.OpMod, "&": code.OpBitAnd,
+			"|": code.OpBitOr, "^": code.OpBitXor, "<<": code.OpShl, ">>": code.OpShr,
+		} {
+			cases[name] = testCase{
+				input:     "1 " + name + " 2",
+				constants: []any{int64(1), int64(2)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(op, nil),
+					code.Make(code.OpPop, nil),
+				},
+			}
+		}
+		cases["< swaps operands"] = testCase{
+			input:     "1 < 2",
+			constants: []any{int64(2), int64(1)},
+			want: []code.Instructions{
+				code.Make(code.OpConstant, []int{0}),
+				code.Make(code.OpConstant, []int{1}),
+				code.Make(code.OpGreaterThan, nil),
+				code.Make(code.OpPop, nil),
+			},
+		}
+		cases["<= swaps operands"] = testCase{
+			input:     "1 <= 2",
+			constants: []any{int64(2), int64(1)},
+			want: []code.Instructions{
+				code.Make(code.OpConstant, []int{0}),
+				code.Make(code.OpConstant, []int{1}),
+				code.Make(code.OpGreaterEqual, nil),
+				code.Make(code.OpPop, nil),
+			},
+		}
+		run(t, cases)
+	})
+	t.Run("Bools", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"True": {
+				input: "true",
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Not False": {
+				input: "!false",
+				want: []code.Instructions{
+					code.Make(code.OpFalse, nil),
+					code.Make(code.OpBang, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"True is not False": {
+				input: "true != false",
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),
+					code.Make(code.OpFalse, nil),
+					code.Make(code.OpNotEqual, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"And": {
+				input: "true && false",
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),                // 0000
+					code.Make(code.OpJumpNotTruthy, []int{12}), // 0001
+					code.Make(code.OpFalse, nil),               // 0004
+					code.Make(code.OpJumpNotTruthy, []int{12}), // 0005
+					code.Make(code.OpTrue, nil),                // 0008
+					code.Make(code.OpJump, []int{13}),          // 0009
+					code.Make(code.OpFalse, nil),               // 0012
+					code.Make(code.OpPop, nil),                 // 0013
+				},
+			},
+			"Or": {
+				input: "true || false",
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),                // 0000
+					code.Make(code.OpBang, nil),                // 0001
+					code.Make(code.OpJumpNotTruthy, []int{14}), // 0002
+					code.Make(code.OpFalse, nil),               // 0005
+					code.Make(code.OpBang, nil),                // 0006
+					code.Make(code.OpJumpNotTruthy, []int{14}), // 0007
+					code.Make(code.OpFalse, nil),               // 0010
+					code.Make(code.OpJump, []int{15}),          // 0011
+					code.Make(code.OpTrue, nil),                // 0014
+					code.Make(code.OpPop, nil),                 // 0015
+				},
+			},
+		})
+	})
+	t.Run("If-Else Expressions", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"No else": {
+				input:     "if (true) { 10 }; 3333;",
+				constants: []any{int64(10), int64(3333)},
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),                // 0000
+					code.Make(code.OpJumpNotTruthy, []int{10}), // 0001
+					code.Make(code.OpConstant, []int{0}),       // 0004
+					code.Make(code.OpJump, []int{11}),          // 0007
+					code.Make(code.OpNull, nil),                // 0010
+					code.Make(code.OpPop, nil),                 // 0011
+					code.Make(code.OpConstant, []int{1}),       // 0012
+					code.Make(code.OpPop, nil),                 // 0015
+				},
+			},
+			"With else": {
+				input:     "if (true) { 10 } else { 20 }; 3333;",
+				constants: []any{int64(10), int64(20), int64(3333)},
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),                // 0000
+					code.Make(code.OpJumpNotTruthy, []int{10}), // 0001
+					code.Make(code.OpConstant, []int{0}),       // 0004
+					code.Make(code.OpJump, []int{13}),          // 0007
+					code.Make(code.OpConstant, []int{1}),       // 0010
+					code.Make(code.OpPop, nil),                 // 0013
+					code.Make(code.OpConstant, []int{2}),       // 0014
+					code.Make(code.OpPop, nil),                 // 0017
+				},
+			},
+			"Empty block": {
+				input: "if (true) { }",
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),
+					code.Make(code.OpJumpNotTruthy, []int{8}),
+					code.Make(code.OpNull, nil),
+					code.Make(code.OpJump, []int{9}),
+					code.Make(code.OpNull, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Let statements", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"Two Lets": {
+				input:     "let a = 5; let b = a; b;",
+				constants: []any{int64(5)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpSetGlobal, []int{0}),
+					code.Make(code.OpGetGlobal, []int{0}),
+					code.Make(code.OpSetGlobal, []int{1}),
+					code.Make(code.OpGetGlobal, []int{1}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Defined later": {
+				input: "let f = fn() { g }; let g = 1;",
+				constants: []any{
+					[]code.Instructions{
+						code.Make(code.OpGetGlobal, []int{1}),
+						code.Make(code.OpReturnValue, nil),
+					},
+					int64(1),
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{0, 0}),
+					code.Make(code.OpSetGlobal, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpSetGlobal, []int{1}),
+				},
+			},
+			"Never defined": {
+				input: "foo;",
+				want: []code.Instructions{
+					code.Make(code.OpGetGlobal, []int{0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Strings", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"Literal": {
+				input:     `"Hey Young Wurld!"`,
+				constants: []any{"Hey Young Wurld!"},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Concatenation": {
+				input:     `"Hey" + " Young Wurld!"`,
+				constants: []any{"Hey", " Young Wurld!"},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpAdd, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Slices and Indexes", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"Empty": {
+				input: "[]",
+				want: []code.Instructions{
+					code.Make(code.OpSlice, []int{0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Index": {
+				input:     "[1, 2][1 + 0]",
+				constants: []any{int64(1), int64(2), int64(1), int64(0)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpSlice, []int{2}),
+					code.Make(code.OpConstant, []int{2}),
+					code.Make(code.OpConstant, []int{3}),
+					code.Make(code.OpAdd, nil),
+					code.Make(code.OpIndex, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Hashes", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"Empty": {
+				input: "{}",
+				want: []code.Instructions{
+					code.Make(code.OpHash, []int{0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Pairs": {
+				input:     "{1: 2 + 3, 4: 5}[1]",
+				constants: []any{int64(1), int64(2), int64(3), int64(4), int64(5), int64(1)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpConstant, []int{2}),
+					code.Make(code.OpAdd, nil),
+					code.Make(code.OpConstant, []int{3}),
+					code.Make(code.OpConstant, []int{4}),
+					code.Make(code.OpHash, []int{4}),
+					code.Make(code.OpConstant, []int{5}),
+					code.Make(code.OpIndex, nil),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Functions", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"Return": {
+				input: "fn(x) { return x + 2; };",
+				constants: []any{
+					int64(2),
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, []int{0}),
+						code.Make(code.OpConstant, []int{0}),
+						code.Make(code.OpAdd, nil),
+						code.Make(code.OpReturnValue, nil),
+					},
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{1, 0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Implicit return": {
+				input: "fn() { 1; 2 }",
+				constants: []any{
+					int64(1),
+					int64(2),
+					[]code.Instructions{
+						code.Make(code.OpConstant, []int{0}),
+						code.Make(code.OpPop, nil),
+						code.Make(code.OpConstant, []int{1}),
+						code.Make(code.OpReturnValue, nil),
+					},
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{2, 0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Empty body": {
+				input: "fn() { }",
+				constants: []any{
+					[]code.Instructions{code.Make(code.OpReturn, nil)},
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{0, 0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Call": {
+				input: "let add = fn(x, y) { x + y }; add(1, 2);",
+				constants: []any{
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, []int{0}),
+						code.Make(code.OpGetLocal, []int{1}),
+						code.Make(code.OpAdd, nil),
+						code.Make(code.OpReturnValue, nil),
+					},
+					int64(1),
+					int64(2),
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{0, 0}),
+					code.Make(code.OpSetGlobal, []int{0}),
+					code.Make(code.OpGetGlobal, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpConstant, []int{2}),
+					code.Make(code.OpCall, []int{2}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Recursive": {
+				input: "let f = fn(x) { f(x) };",
+				constants: []any{
+					[]code.Instructions{
+						code.Make(code.OpCurrentClosure, nil),
+						code.Make(code.OpGetLocal, []int{0}),
+						code.Make(code.OpCall, []int{1}),
+						code.Make(code.OpReturnValue, nil),
+					},
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{0, 0}),
+					code.Make(code.OpSetGlobal, []int{0}),
+				},
+			},
+			"Builtin": {
+				input:     `len("")`,
+				constants: []any{""},
+				want: []code.Instructions{
+					code.Make(code.OpGetBuiltin, []int{0}),
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpCall, []int{1}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Closures", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"Adder": {
+				input: "fn(x) { fn(y) { x + y } }",
+				constants: []any{
+					[]code.Instructions{
+						code.Make(code.OpGetFree, []int{0}),
+						code.Make(code.OpGetLocal, []int{0}),
+						code.Make(code.OpAdd, nil),
+						code.Make(code.OpReturnValue, nil),
+					},
+					[]code.Instructions{
+						code.Make(code.OpCaptureLocal, []int{0}),
+						code.Make(code.OpClosure, []int{0, 1}),
+						code.Make(code.OpReturnValue, nil),
+					},
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{1, 0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+			"Assign free": {
+				input: "fn(x) { fn() { x += 1 } }",
+				constants: []any{
+					int64(1),
+					[]code.Instructions{
+						code.Make(code.OpGetFree, []int{0}),
+						code.Make(code.OpConstant, []int{0}),
+						code.Make(code.OpAdd, nil),
+						code.Make(code.OpSetFree, []int{0}),
+						code.Make(code.OpReturn, nil),
+					},
+					[]code.Instructions{
+						code.Make(code.OpCaptureLocal, []int{0}),
+						code.Make(code.OpClosure, []int{1, 1}),
+						code.Make(code.OpReturnValue, nil),
+					},
+				},
+				want: []code.Instructions{
+					code.Make(code.OpClosure, []int{2, 0}),
+					code.Make(code.OpPop, nil),
+				},
+			},
+		})
+	})
+	t.Run("Assignments", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"Global": {
+				input:     "let x = 1; x = 2;",
+				constants: []any{int64(1), int64(2)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpSetGlobal, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpSetGlobal, []int{0}),
+				},
+			},
+			"Compound": {
+				input:     "let x = 1; x *= 2;",
+				constants: []any{int64(1), int64(2)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpSetGlobal, []int{0}),
+					code.Make(code.OpGetGlobal, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpMul, nil),
+					code.Make(code.OpSetGlobal, []int{0}),
+				},
+			},
+			"Index": {
+				input:     "let xs = [1]; xs[0] -= 2;",
+				constants: []any{int64(1), int64(0), int64(2)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),
+					code.Make(code.OpSlice, []int{1}),
+					code.Make(code.OpSetGlobal, []int{0}),
+					code.Make(code.OpGetGlobal, []int{0}),
+					code.Make(code.OpConstant, []int{1}),
+					code.Make(code.OpConstant, []int{2}),
+					code.Make(code.OpSetIndex, []int{int(code.OpSub)}),
+				},
+			},
+		})
+	})
+	t.Run("Loops", func(t *testing.T) {
+		t.Parallel()
+		run(t, map[string]testCase{
+			"While": {
+				input: "while (true) { break; }",
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),                // 0000
+					code.Make(code.OpJumpNotTruthy, []int{10}), // 0001
+					code.Make(code.OpJump, []int{10}),          // 0004
+					code.Make(code.OpJump, []int{0}),           // 0007
+				},
+			},
+			"For": {
+				input:     "for (x in [1]) { continue; }",
+				constants: []any{int64(1)},
+				want: []code.Instructions{
+					code.Make(code.OpConstant, []int{0}),  // 0000
+					code.Make(code.OpSlice, []int{1}),     // 0003
+					code.Make(code.OpIter, nil),           // 0006
+					code.Make(code.OpNext, []int{19}),     // 0007
+					code.Make(code.OpSetGlobal, []int{0}), // 0010
+					code.Make(code.OpJump, []int{7}),      // 0013
+					code.Make(code.OpJump, []int{7}),      // 0016
+					code.Make(code.OpDrop, nil),           // 0019
+					code.Make(code.OpDrop, nil),           // 0020
+				},
+			},
+			"Break out of a for": {
+				input: "outer: while (true) { for (x in []) { break outer; } }",
+				want: []code.Instructions{
+					code.Make(code.OpTrue, nil),                // 0000
+					code.Make(code.OpJumpNotTruthy, []int{27}), // 0001
+					code.Make(code.OpSlice, []int{0}),          // 0004
+					code.Make(code.OpIter, nil),                // 0007
+					code.Make(code.OpNext, []int{22}),          // 0008
+					code.Make(code.OpSetGlobal, []int{0}),      // 0011
+					code.Make(code.OpDrop, nil),                // 0014
+					code.Make(code.OpDrop, nil),                // 0015
+					code.Make(code.OpJump, []int{27}),          // 0016
+					code.Make(code.OpJump, []int{8}),           // 0019
+					code.Make(code.OpDrop, nil),                // 0022
+					code.Make(code.OpDrop, nil),                // 0023
+					code.Make(code.OpJump, []int{0}),           // 0024
+				},
+			},
+		})
+	})
+	t.Run("Imports", func(t *testing.T) {
+		t.Parallel()
+		c := compiler.New()
+		c.SetLoader(module.FSLoader{FS: fstest.MapFS{
+			"m.mmm": {Data: []byte("let x = 1; let _y = 2;")},
+		}}, "")
+		if err := c.Compile(parser.New(lexer.New(`import "m.mmm"; import "m.mmm"`)).Parse()); err != nil {
+			t.Fatal(err)
+		}
+		bc := c.Bytecode()
+		checkInstructions(t, []code.Instructions{
+			code.Make(code.OpImport, []int{0, 17}), // 0000
+			code.Make(code.OpClosure, []int{4, 0}), // 0005
+			code.Make(code.OpCall, []int{0}),       // 0009
+			code.Make(code.OpSetGlobal, []int{0}),  // 0011
+			code.Make(code.OpGetGlobal, []int{0}),  // 0014
+			code.Make(code.OpPop, nil),             // 0017
+			code.Make(code.OpImport, []int{0, 35}), // 0018
+			code.Make(code.OpClosure, []int{4, 0}), // 0023
+			code.Make(code.OpCall, []int{0}),       // 0027
+			code.Make(code.OpSetGlobal, []int{0}),  // 0029
+			code.Make(code.OpGetGlobal, []int{0}),  // 0032
+			code.Make(code.OpPop, nil),             // 0035
+		}, bc.Instructions)
+		checkConstants(t, []any{
+			int64(1),
+			int64(2),
+			"x",
+			"m.mmm",
+			[]code.Instructions{
+				code.Make(code.OpConstant, []int{0}),
+				code.Make(code.OpSetLocal, []int{0}),
+				code.Make(code.OpConstant, []int{1}),
+				code.Make(code.OpSetLocal, []int{1}),
+				code.Make(code.OpConstant, []int{2}),
+				code.Make(code.OpCaptureLocal, []int{0}),
+				code.Make(code.OpModule, []int{3, 2}),
+				code.Make(code.OpReturnValue, nil),
+			},
+		}, bc.Constants)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Assign undeclared": {input: "x = 1;", want: "1:1: assignment to undeclared identifier: x"},
+			"Assign builtin":    {input: "len = 1;", want: "1:1: assignment to undeclared identifier: len"},
+			"Import disabled":   {input: `import "m.mmm"`, want: `1:1: cannot import "m.mmm": imports are not enabled`},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				c := compiler.New()
+				err := c.Compile(parser.New(lexer.New(tc.input)).Parse())
+				is.Equal(t, tc.want, err.Error())
+			})
+		}
+	})
+	t.Run("Limits", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Locals":             {input: "fn() { " + repeat("let v_%s = 0;", 256, " ") + " }"},
+			"Too many locals":    {input: "fn() { " + repeat("let v_%s = 0;", 257, " ") + " }", want: "too many local bindings"},
+			"Arguments":          {input: "len(" + repeat("%d", 255, ", ") + ")"},
+			"Too many arguments": {input: "len(" + repeat("%d", 256, ", ") + ")", want: "too many arguments"},
+			"Elements":           {input: "[" + repeat("true", 65535, ", ") + "]"},
+			"Too many elements":  {input: "[" + repeat("true", 65536, ", ") + "]", want: "too many elements"},
+			"Constants":          {input: repeat("%d;", 65536, " ")},
+			"Too many constants": {input: repeat("%d;", 65537, " "), want: "too many constants"},
+			"Globals":            {input: repeat("let g_%s = true;", 65536, " ")},
+			"Too many globals":   {input: repeat("let g_%s = true;", 65537, " "), want: "too many global bindings"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				err := compiler.New().Compile(parser.New(lexer.New(tc.input)).Parse())
+				if tc.want == "" {
+					if err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				is.Equal(t, true, strings.HasSuffix(err.Error(), ": "+tc.want))
+			})
+		}
+	})
+}
+
+// repeat joins n copies of format with sep, each formatted with its index as
+// a %d, or as letters for a %s since identifiers can't have digits.
+func repeat(format string, n int, sep string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		switch {
+		case strings.Contains(format, "%d"):
+			parts[i] = fmt.Sprintf(format, i)
+		case strings.Contains(format, "%s"):
+			var name []byte
+			for j := i; ; j /= 26 {
+				name = append(name, byte('a'+j%26))
+				if j < 26 {
+					break
+				}
+			}
+			parts[i] = fmt.Sprintf(format, name)
+		default:
+			parts[i] = format
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func TestSymbolTable_Resolve(t *testing.T) {
+	t.Parallel()
+	global := compiler.NewSymbolTable()
+	global.Define("a")
+	local := compiler.NewEnclosedSymbolTable(global)
+	local.Define("b")
+	nested := compiler.NewEnclosedSymbolTable(local)
+	nested.Define("c")
+
+	for name, want := range map[string]compiler.Symbol{
+		"a": {Name: "a", Scope: compiler.ScopeGlobal, Index: 0},
+		"b": {Name: "b", Scope: compiler.ScopeFree, Index: 0},
+		"c": {Name: "c", Scope: compiler.ScopeLocal, Index: 0},
+	} {
+		got, ok := nested.Resolve(name)
+		is.Equal(t, true, ok)
+		is.Equal(t, want, got)
+	}
+	is.Equal(t, 1, len(nested.Free))
+	is.Equal(t, compiler.ScopeLocal, nested.Free[0].Scope)
+	_, ok := nested.Resolve("d")
+	is.Equal(t, false, ok)
+}
+
+func TestSymbolTable_Define(t *testing.T) {
+	t.Parallel()
+	global := compiler.NewSymbolTable()
+	a := global.Define("a")
+	global.Define("b")
+	is.Equal(t, a, global.Define("a"))
+	local := compiler.NewEnclosedSymbolTable(global)
+	is.Equal(t, compiler.Symbol{Name: "a", Scope: compiler.ScopeLocal, Index: 0},
+		local.Define("a"))
+}
+
+func run(t *testing.T, cases map[string]testCase) {
+	t.Helper()
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p := parser.New(lexer.New(tc.input))
+			program := p.Parse()
+			is.Equal(t, 0, len(p.Errors()))
+			c := compiler.New()
+			if err := c.Compile(program); err != nil {
+				t.Fatal(err)
+			}
+			bc := c.Bytecode()
+			checkInstructions(t, tc.want, bc.Instructions)
+			checkConstants(t, tc.constants, bc.Constants)
+		})
+	}
+}
+
+func checkInstructions(t *testing.T, want []code.Instructions, got code.Instructions) {
+	t.Helper()
+	var flat code.Instructions
+	for _, ins := range want {
+		flat = append(flat, ins...)
+	}
+	is.Equal(t, flat.String(), got.String())
+}
+
+func checkConstants(t *testing.T, want []any, got []entity.E) {
+	t.Helper()
+	is.Equal(t, len(want), len(got))
+	for i, w := range want {
+		switch w := w.(type) {
+		case int64:
+			is.Equal(t, w, got[i].(entity.Int).Value)
+		case string:
+			is.Equal(t, w, got[i].(entity.String).Value)
+		case []code.Instructions:
+			checkInstructions(t, w, got[i].(entity.CompiledFn).Instructions)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package compiler
+
+import (
+	"maps"
+	"slices"
+	"sort"
+)
+
+// Scope is where a Symbol lives, which decides which opcode the compiler uses
+// to load and store it.
+type Scope uint8
+
+const (
+	ScopeGlobal Scope = iota
+	ScopeLocal
+	ScopeBuiltin
+	// ScopeFree is a local of an enclosing function captured by a closure.
+	ScopeFree
+	// ScopeFunction is the name a function was bound to with let, so it can call
+	// itself recursively.
+	ScopeFunction
+	// ScopeHost is a function or constant of an [entity.Host], its Index is the
+	// constant it was compiled to.
+	ScopeHost
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeGlobal:
+		return "Global"
+	case ScopeLocal:
+		return "Local"
+	case ScopeBuiltin:
+		return "Builtin"
+	case ScopeFree:
+		return "Free"
+	case ScopeFunction:
+		return "Function"
+	case ScopeHost:
+		return "Host"
+	default:
+		return "Unknown"
+	}
+}
+
+// Symbol is an identifier the compiler has seen along with where to find it.
+type Symbol struct {
+	Name  string
+	Scope Scope
+	Index int
+}
+
+// SymbolTable tracks the identifiers defined in a single scope. Every function
+// being compiled gets its own SymbolTable with the enclosing one as its outer.
+type SymbolTable struct {
+	outer *SymbolTable
+	store map[string]Symbol
+	// defs is how many symbols have been defined, it doubles as the next index.
+	defs int
+	// Free are the symbols from outer scopes this scope has captured, in the
+	// order they need to be pushed on the stack when creating a closure.
+	Free []Symbol
+	// imports are the modules imported so far by module name, they're kept
+	// apart from store because they can't be referred to by name.
+	imports map[string]imported
+	// host are the ScopeHost symbols, kept apart from store so that modules
+	// still see them when the program shadows them.
+	host []Symbol
+}
+
+// imported is a module that's been compiled.
+type imported struct {
+	// global is the index of the global holding the Module once it's been
+	// evaluated.
+	global int
+	// fn is the constant index of the function that evaluates it.
+	fn int
+}
+
+// NewSymbolTable returns an empty global SymbolTable.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: map[string]Symbol{}, imports: map[string]imported{}}
+}
+
+// NewEnclosedSymbolTable returns an empty SymbolTable nested inside outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.outer = outer
+	return s
+}
+
+// Copy returns a copy of s that symbols can be defined in without changing s,
+// so that they can be thrown away when what defined them fails to compile.
+func (s *SymbolTable) Copy() *SymbolTable {
+	cp := *s
+	cp.store, cp.imports = maps.Clone(s.store), maps.Clone(s.imports)
+	cp.Free, cp.host = slices.Clip(s.Free), slices.Clip(s.host)
+	return &cp
+}
+
+// Define adds name to the table, shadowing anything already called name. A name
+// already defined by this table keeps its index so that defining it again, e.g.
+// in the body of a loop, overwrites it the same way the eval package does.
+func (s *SymbolTable) Define(name string) Symbol {
+	sym := Symbol{Name: name, Scope: ScopeLocal, Index: s.defs}
+	if s.outer == nil {
+		sym.Scope = ScopeGlobal
+	}
+	if prev, ok := s.store[name]; ok && prev.Scope == sym.Scope {
+		return prev
+	}
+	s.store[name] = sym
+	s.defs++
+	return sym
+}
+
+// defineImport reserves a global for the module called name, fn is the
+// constant index of the function that evaluates it.
+func (s *SymbolTable) defineImport(name string, fn int) imported {
+	m := imported{global: s.defs, fn: fn}
+	s.imports[name] = m
+	s.defs++
+	return m
+}
+
+// DefineBuiltin adds the builtin name found at index of [entity.Builtins].
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	sym := Symbol{Name: name, Scope: ScopeBuiltin, Index: index}
+	s.store[name] = sym
+	return sym
+}
+
+// DefineHost adds the name of an [entity.Host] value compiled to the constant
+// at index.
+func (s *SymbolTable) DefineHost(index int, name string) Symbol {
+	sym := Symbol{Name: name, Scope: ScopeHost, Index: index}
+	s.store[name] = sym
+	s.host = append(s.host, sym)
+	return sym
+}
+
+// DefineFunctionName adds the name of the function currently being compiled.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	sym := Symbol{Name: name, Scope: ScopeFunction}
+	s.store[name] = sym
+	return sym
+}
+
+// Symbols are every symbol defined directly in s ordered by scope and index.
+func (s *SymbolTable) Symbols() []Symbol {
+	syms := make([]Symbol, 0, len(s.store))
+	for _, sym := range s.store {
+		syms = append(syms, sym)
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].Scope != syms[j].Scope {
+			return syms[i].Scope < syms[j].Scope
+		}
+		return syms[i].Index < syms[j].Index
+	})
+	return syms
+}
+
+// names are the names of the symbols of scope defined directly in s by index,
+// the indexes nothing was defined at, like those of imports, are empty.
+func (s *SymbolTable) names(scope Scope) []string {
+	names := make([]string, s.defs)
+	for _, sym := range s.store {
+		if sym.Scope == scope {
+			names[sym.Index] = sym.Name
+		}
+	}
+	return names
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.Free = append(s.Free, original)
+	sym := Symbol{Name: original.Name, Scope: ScopeFree, Index: len(s.Free) - 1}
+	s.store[original.Name] = sym
+	return sym
+}
+
+// Resolve finds name in this table or any outer one. Locals of an enclosing
+// function are turned into free variables on the way back in.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	sym, ok := s.store[name]
+	if ok || s.outer == nil {
+		return sym, ok
+	}
+	sym, ok = s.outer.Resolve(name)
+	if !ok {
+		return sym, ok
+	}
+	if sym.Scope == ScopeGlobal || sym.Scope == ScopeBuiltin || sym.Scope == ScopeHost {
+		return sym, ok
+	}
+	return s.defineFree(sym), true
+}
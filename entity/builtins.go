@@ -0,0 +1,392 @@
+package entity
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builtins are the functions available to every mmm program without having to
+// be defined. The order matters, the compiler refers to a builtin by its index
+// so new builtins must only ever be appended.
+var Builtins = []struct {
+	Name    string
+	Builtin Builtin
+}{
+	{
+		Name: "len",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if len(e) != 1 {
+				return NewError(KindArity, "len only accepts one argument.")
+			}
+			switch v := e[0].(type) {
+			case String:
+				return Int{Value: int64(utf8.RuneCountInString(v.Value))}
+			case Slice:
+				return Int{Value: int64(len(v.Values))}
+			case Hash:
+				return Int{Value: int64(len(v.Pairs))}
+			default:
+				return NewError(KindType, "argument to `len` not supported, got %s", v.Type())
+			}
+		}},
+	},
+	{Name: "puts", Builtin: Builtin{Fn: Puts(os.Stdout)}},
+	{Name: "print", Builtin: Builtin{Fn: Print(os.Stdout)}},
+	{
+		Name: "first",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("first", e, TypeSlice); err != nil {
+				return err
+			}
+			if s := e[0].(Slice); len(s.Values) > 0 {
+				return s.Values[0]
+			}
+			return Null{}
+		}},
+	},
+	{
+		Name: "last",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("last", e, TypeSlice); err != nil {
+				return err
+			}
+			if s := e[0].(Slice); len(s.Values) > 0 {
+				return s.Values[len(s.Values)-1]
+			}
+			return Null{}
+		}},
+	},
+	{
+		// rest is every element but the first in a new Slice.
+		Name: "rest",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("rest", e, TypeSlice); err != nil {
+				return err
+			}
+			s := e[0].(Slice)
+			if len(s.Values) == 0 {
+				return Null{}
+			}
+			vals := make([]E, len(s.Values)-1)
+			copy(vals, s.Values[1:])
+			return Slice{Values: vals}
+		}},
+	},
+	{
+		// push returns a new Slice with the value added to the end, the
+		// original is left as it was.
+		Name: "push",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("push", e, TypeSlice, TypeAny); err != nil {
+				return err
+			}
+			s := e[0].(Slice)
+			vals := make([]E, len(s.Values), len(s.Values)+1)
+			copy(vals, s.Values)
+			return Slice{Values: append(vals, e[1])}
+		}},
+	},
+	{
+		// concat joins any number of slices into a new Slice.
+		Name: "concat",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			var vals []E
+			for i, v := range e {
+				s, ok := v.(Slice)
+				if !ok {
+					return argTypeErr("concat", i, v, TypeSlice)
+				}
+				vals = append(vals, s.Values...)
+			}
+			return Slice{Values: append([]E{}, vals...)}
+		}},
+	},
+	{
+		// type is the name of the type of its argument.
+		Name: "type",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("type", e, TypeAny); err != nil {
+				return err
+			}
+			return String{Value: e[0].Type().String()}
+		}},
+	},
+	{
+		Name: "str",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("str", e, TypeAny); err != nil {
+				return err
+			}
+			return String{Value: e[0].Inspect()}
+		}},
+	},
+	{
+		Name: "int",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("int", e, TypeAny); err != nil {
+				return err
+			}
+			switch v := e[0].(type) {
+			case Int, BigInt:
+				return v
+			case Float:
+				if math.IsInf(v.Value, 0) || math.IsNaN(v.Value) {
+					return Errorf("could not convert %s to Int", v.Inspect())
+				}
+				i, _ := big.NewFloat(v.Value).Int(nil)
+				return NewInt(i)
+			case Bool:
+				if v.Value {
+					return Int{Value: 1}
+				}
+				return Int{Value: 0}
+			case String:
+				i, ok := new(big.Int).SetString(strings.TrimSpace(v.Value), 0)
+				if !ok {
+					return Errorf("could not convert %q to Int", v.Value)
+				}
+				return NewInt(i)
+			default:
+				return NewError(KindType, "argument to `int` not supported, got %s", v.Type())
+			}
+		}},
+	},
+	{
+		Name: "split",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("split", e, TypeString, TypeString); err != nil {
+				return err
+			}
+			parts := strings.Split(e[0].(String).Value, e[1].(String).Value)
+			vals := make([]E, len(parts))
+			for i, p := range parts {
+				vals[i] = String{Value: p}
+			}
+			return Slice{Values: vals}
+		}},
+	},
+	{
+		Name: "join",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("join", e, TypeSlice, TypeString); err != nil {
+				return err
+			}
+			s := e[0].(Slice)
+			vals := make([]string, len(s.Values))
+			for i, v := range s.Values {
+				vals[i] = v.Inspect()
+			}
+			return String{Value: strings.Join(vals, e[1].(String).Value)}
+		}},
+	},
+	{
+		// contains reports whether a String has a substring, a Slice has an
+		// element or a Hash has a key.
+		Name: "contains",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("contains", e, TypeAny, TypeAny); err != nil {
+				return err
+			}
+			switch v := e[0].(type) {
+			case String:
+				sub, ok := e[1].(String)
+				if !ok {
+					return argTypeErr("contains", 1, e[1], TypeString)
+				}
+				return Bool{Value: strings.Contains(v.Value, sub.Value)}
+			case Slice:
+				for _, el := range v.Values {
+					if Equal(el, e[1]) {
+						return Bool{Value: true}
+					}
+				}
+				return Bool{Value: false}
+			case Hash:
+				k, ok := e[1].(Hashable)
+				if !ok {
+					return NewError(KindIndex, "unusable as hash key: %s", e[1].Type())
+				}
+				_, ok = v.Pairs[k.HashKey()]
+				return Bool{Value: ok}
+			default:
+				return NewError(KindType, "argument to `contains` not supported, got %s", v.Type())
+			}
+		}},
+	},
+	{
+		// keys are the keys of a Hash in the same order Inspect shows them.
+		Name: "keys",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("keys", e, TypeHash); err != nil {
+				return err
+			}
+			pairs := e[0].(Hash).Sorted()
+			vals := make([]E, len(pairs))
+			for i, p := range pairs {
+				vals[i] = p.Key
+			}
+			return Slice{Values: vals}
+		}},
+	},
+	{
+		// values are the values of a Hash in the same order as keys.
+		Name: "values",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("values", e, TypeHash); err != nil {
+				return err
+			}
+			pairs := e[0].(Hash).Sorted()
+			vals := make([]E, len(pairs))
+			for i, p := range pairs {
+				vals[i] = p.Value
+			}
+			return Slice{Values: vals}
+		}},
+	},
+	{
+		Name: "float",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("float", e, TypeAny); err != nil {
+				return err
+			}
+			switch v := e[0].(type) {
+			case Float:
+				return v
+			case Int, BigInt:
+				return Float{Value: toFloat(v)}
+			case String:
+				f, err := strconv.ParseFloat(strings.TrimSpace(v.Value), 64)
+				if err != nil {
+					return Errorf("could not convert %q to Float", v.Value)
+				}
+				return Float{Value: f}
+			default:
+				return NewError(KindType, "argument to `float` not supported, got %s", v.Type())
+			}
+		}},
+	},
+	{
+		// bytes is the UTF-8 encoding of a String as a Slice of Ints, for when
+		// its bytes matter rather than its characters.
+		Name: "bytes",
+		Builtin: Builtin{Fn: func(e ...E) E {
+			if err := CheckArgs("bytes", e, TypeString); err != nil {
+				return err
+			}
+			s := e[0].(String).Value
+			vals := make([]E, len(s))
+			for i := 0; i < len(s); i++ {
+				vals[i] = Int{Value: int64(s[i])}
+			}
+			return Slice{Values: vals}
+		}},
+	},
+}
+
+// Puts is the puts builtin writing to w, it writes every argument on its own
+// line. The puts of [Builtins] writes to os.Stdout, see [Host.Output] for
+// writing somewhere else.
+func Puts(w io.Writer) BuiltinFn {
+	return func(e ...E) E {
+		for _, v := range e {
+			fmt.Fprintln(w, v.Inspect())
+		}
+		return Null{}
+	}
+}
+
+// Print is the print builtin writing to w, it writes its arguments separated
+// by spaces without a newline.
+func Print(w io.Writer) BuiltinFn {
+	return func(e ...E) E {
+		vals := make([]string, len(e))
+		for i, v := range e {
+			vals[i] = v.Inspect()
+		}
+		fmt.Fprint(w, strings.Join(vals, " "))
+		return Null{}
+	}
+}
+
+// GetBuiltin returns the builtin called name.
+func GetBuiltin(name string) (Builtin, bool) {
+	for _, b := range Builtins {
+		if b.Name == name {
+			return b.Builtin, true
+		}
+	}
+	return Builtin{}, false
+}
+
+// TypeAny can be passed to CheckArgs to accept an argument of any type.
+const TypeAny Type = 255
+
+// CheckArgs returns an Error when args doesn't have exactly one argument per
+// type in want, or when an argument isn't of its wanted type. name is the
+// function being called, used in the message.
+func CheckArgs(name string, args []E, want ...Type) E {
+	if err := CheckArity(name, args, len(want), len(want)); err != nil {
+		return err
+	}
+	for i, t := range want {
+		if err := CheckType(name, args, i, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckArity returns an Error when there are fewer than min or more than max
+// args, a negative max being no maximum. name is the function being called,
+// used in the message.
+func CheckArity(name string, args []E, min, max int) E {
+	n := len(args)
+	switch {
+	case min == max && n != min:
+		return NewError(KindArity, "wrong number of arguments to `%s`: want=%d, got=%d",
+			name, min, n)
+	case max < 0 && n < min:
+		return NewError(KindArity, "wrong number of arguments to `%s`: want>=%d, got=%d",
+			name, min, n)
+	case max >= 0 && (n < min || n > max):
+		return NewError(KindArity, "wrong number of arguments to `%s`: want=%d..%d, got=%d",
+			name, min, max, n)
+	}
+	return nil
+}
+
+// CheckType returns an Error when the argument at index i of args isn't one of
+// the types in want. name is the function being called, used in the message.
+func CheckType(name string, args []E, i int, want ...Type) E {
+	for _, t := range want {
+		if t == TypeAny || args[i].Type() == t {
+			return nil
+		}
+	}
+	return argTypeErr(name, i, args[i], want...)
+}
+
+func argTypeErr(name string, i int, got E, want ...Type) Error {
+	types := make([]string, len(want))
+	for i, t := range want {
+		types[i] = t.String()
+	}
+	return NewError(KindType, "argument %d to `%s` must be %s, got %s",
+		i+1, name, strings.Join(types, " or "), got.Type())
+}
+
+// Equal reports whether a and b are the same value. Only Hashable entities
+// and Null can be compared, anything else is never equal.
+func Equal(a, b E) bool {
+	if ak, ok := a.(Hashable); ok {
+		bk, ok := b.(Hashable)
+		return ok && ak.HashKey() == bk.HashKey()
+	}
+	return a.Type() == TypeNull && b.Type() == TypeNull
+}
@@ -0,0 +1,147 @@
+package entity_test
+
+import (
+	"bytes"
+	"testing"
+
+	"mmm/entity"
+	"mmm/is"
+)
+
+func TestBuiltins(t *testing.T) {
+	var (
+		one, two = entity.Int{Value: 1}, entity.Int{Value: 2}
+		a, b     = entity.String{Value: "a"}, entity.String{Value: "b"}
+		slice    = entity.Slice{Values: []entity.E{one, two}}
+		empty    = entity.Slice{}
+		hash     = entity.Hash{Pairs: map[entity.HashKey]entity.HashPair{
+			b.HashKey():   {Key: b, Value: two},
+			one.HashKey(): {Key: one, Value: a},
+		}}
+	)
+	for name, tc := range map[string]struct {
+		fn   string
+		args []entity.E
+		want string
+	}{
+		"len Hash":              {fn: "len", args: []entity.E{hash}, want: "2"},
+		"len String":            {fn: "len", args: []entity.E{entity.String{Value: "né"}}, want: "2"},
+		"bytes":                 {fn: "bytes", args: []entity.E{entity.String{Value: "né"}}, want: "[110, 195, 169]"},
+		"bytes wrong type":      {fn: "bytes", args: []entity.E{one}, want: "ERROR: argument 1 to `bytes` must be String, got Int"},
+		"first":                 {fn: "first", args: []entity.E{slice}, want: "1"},
+		"first empty":           {fn: "first", args: []entity.E{empty}, want: "null"},
+		"first wrong type":      {fn: "first", args: []entity.E{one}, want: "ERROR: argument 1 to `first` must be Slice, got Int"},
+		"first no args":         {fn: "first", want: "ERROR: wrong number of arguments to `first`: want=1, got=0"},
+		"last":                  {fn: "last", args: []entity.E{slice}, want: "2"},
+		"last empty":            {fn: "last", args: []entity.E{empty}, want: "null"},
+		"rest":                  {fn: "rest", args: []entity.E{slice}, want: "[2]"},
+		"rest empty":            {fn: "rest", args: []entity.E{empty}, want: "null"},
+		"push":                  {fn: "push", args: []entity.E{slice, a}, want: "[1, 2, a]"},
+		"push too many":         {fn: "push", args: []entity.E{slice, a, b}, want: "ERROR: wrong number of arguments to `push`: want=2, got=3"},
+		"concat":                {fn: "concat", args: []entity.E{slice, empty, slice}, want: "[1, 2, 1, 2]"},
+		"concat nothing":        {fn: "concat", want: "[]"},
+		"concat wrong type":     {fn: "concat", args: []entity.E{slice, a}, want: "ERROR: argument 2 to `concat` must be Slice, got String"},
+		"type":                  {fn: "type", args: []entity.E{hash}, want: "Hash"},
+		"str":                   {fn: "str", args: []entity.E{slice}, want: "[1, 2]"},
+		"int String":            {fn: "int", args: []entity.E{entity.String{Value: " 42 "}}, want: "42"},
+		"int Bool":              {fn: "int", args: []entity.E{entity.Bool{Value: true}}, want: "1"},
+		"int bad String":        {fn: "int", args: []entity.E{a}, want: `ERROR: could not convert "a" to Int`},
+		"int big String":        {fn: "int", args: []entity.E{entity.String{Value: "1_000_000_000_000_000_000_000"}}, want: "1000000000000000000000"},
+		"int Float":             {fn: "int", args: []entity.E{entity.Float{Value: -1.9}}, want: "-1"},
+		"float Int":             {fn: "float", args: []entity.E{two}, want: "2.0"},
+		"float String":          {fn: "float", args: []entity.E{entity.String{Value: "1e-3"}}, want: "0.001"},
+		"float bad String":      {fn: "float", args: []entity.E{a}, want: `ERROR: could not convert "a" to Float`},
+		"float Slice":           {fn: "float", args: []entity.E{slice}, want: "ERROR: argument to `float` not supported, got Slice"},
+		"int Slice":             {fn: "int", args: []entity.E{slice}, want: "ERROR: argument to `int` not supported, got Slice"},
+		"split":                 {fn: "split", args: []entity.E{entity.String{Value: "a,b"}, entity.String{Value: ","}}, want: "[a, b]"},
+		"split wrong type":      {fn: "split", args: []entity.E{a, one}, want: "ERROR: argument 2 to `split` must be String, got Int"},
+		"join":                  {fn: "join", args: []entity.E{slice, entity.String{Value: "-"}}, want: "1-2"},
+		"contains String":       {fn: "contains", args: []entity.E{entity.String{Value: "abc"}, b}, want: "true"},
+		"contains String wrong": {fn: "contains", args: []entity.E{a, one}, want: "ERROR: argument 2 to `contains` must be String, got Int"},
+		"contains Slice":        {fn: "contains", args: []entity.E{slice, two}, want: "true"},
+		"contains Slice not":    {fn: "contains", args: []entity.E{slice, a}, want: "false"},
+		"contains Hash":         {fn: "contains", args: []entity.E{hash, b}, want: "true"},
+		"contains Hash not":     {fn: "contains", args: []entity.E{hash, two}, want: "false"},
+		"contains Hash Float":   {fn: "contains", args: []entity.E{hash, entity.Float{Value: 1}}, want: "true"},
+		"contains Int":          {fn: "contains", args: []entity.E{one, one}, want: "ERROR: argument to `contains` not supported, got Int"},
+		"keys":                  {fn: "keys", args: []entity.E{hash}, want: "[1, b]"},
+		"values":                {fn: "values", args: []entity.E{hash}, want: "[a, 2]"},
+		"values wrong type":     {fn: "values", args: []entity.E{slice}, want: "ERROR: argument 1 to `values` must be Hash, got Slice"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			b, ok := entity.GetBuiltin(tc.fn)
+			is.Equal(t, true, ok)
+			is.Equal(t, tc.want, b.Fn(tc.args...).Inspect())
+		})
+	}
+}
+
+func TestBuiltins_Output(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	puts, print := entity.Puts(&out), entity.Print(&out)
+	is.Equal(t, entity.TypeNull, puts(entity.String{Value: "a"}, entity.Int{Value: 1}).Type())
+	is.Equal(t, entity.TypeNull, print(entity.String{Value: "b"}, entity.Int{Value: 2}).Type())
+	is.Equal(t, "a\n1\nb 2", out.String())
+}
+
+func TestCheckArity(t *testing.T) {
+	t.Parallel()
+	one := entity.Int{Value: 1}
+	for name, tc := range map[string]struct {
+		args     []entity.E
+		min, max int
+		want     string
+	}{
+		"Exact":         {args: []entity.E{one}, min: 1, max: 1},
+		"Exact wrong":   {min: 1, max: 1, want: "wrong number of arguments to `f`: want=1, got=0"},
+		"Range":         {args: []entity.E{one, one}, min: 1, max: 2},
+		"Range too few": {min: 1, max: 2, want: "wrong number of arguments to `f`: want=1..2, got=0"},
+		"Range too many": {args: []entity.E{one, one, one}, min: 1, max: 2,
+			want: "wrong number of arguments to `f`: want=1..2, got=3"},
+		"At least":         {args: []entity.E{one, one, one}, min: 1, max: -1},
+		"At least too few": {min: 1, max: -1, want: "wrong number of arguments to `f`: want>=1, got=0"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := entity.CheckArity("f", tc.args, tc.min, tc.max)
+			if tc.want == "" {
+				is.Equal(t, nil, err)
+				return
+			}
+			is.Equal(t, entity.KindArity, err.(entity.Error).Kind)
+			is.Equal(t, tc.want, err.(entity.Error).Message)
+		})
+	}
+}
+
+func TestCheckType(t *testing.T) {
+	t.Parallel()
+	args := []entity.E{entity.Int{Value: 1}, entity.String{Value: "a"}}
+	for name, tc := range map[string]struct {
+		i    int
+		want []entity.Type
+		err  string
+	}{
+		"Type":         {i: 0, want: []entity.Type{entity.TypeInt}},
+		"One of":       {i: 1, want: []entity.Type{entity.TypeInt, entity.TypeString}},
+		"Any":          {i: 1, want: []entity.Type{entity.TypeAny}},
+		"Wrong":        {i: 1, want: []entity.Type{entity.TypeInt}, err: "argument 2 to `f` must be Int, got String"},
+		"None of them": {i: 0, want: []entity.Type{entity.TypeFloat, entity.TypeString}, err: "argument 1 to `f` must be Float or String, got Int"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := entity.CheckType("f", args, tc.i, tc.want...)
+			if tc.err == "" {
+				is.Equal(t, nil, err)
+				return
+			}
+			is.Equal(t, entity.KindType, err.(entity.Error).Kind)
+			is.Equal(t, tc.err, err.(entity.Error).Message)
+		})
+	}
+}
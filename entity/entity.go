@@ -16,10 +16,16 @@ package entity
 
 import (
 	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 
 	"mmm/ast"
+	"mmm/code"
 )
 
 // E is an Entity that satisfies having a type in the mmm language and has a
@@ -34,6 +40,39 @@ type BuiltinFn func(...E) E
 type Env struct {
 	parent *Env
 	store map[string]E
+	// importer is inherited by the Envs enclosed by this one, so that functions
+	// import relative to the module they were defined in.
+	importer Importer
+	// meter is inherited like importer, so that it's charged for the functions
+	// called wherever they were defined.
+	meter Meter
+	// host is inherited like importer.
+	host *Host
+}
+
+// Importer finds the Module of an import, path being the module as it was
+// written in the program and env the Env the import is evaluated in.
+type Importer interface {
+	Import(path string, env Env) E
+}
+
+// Meter is charged for the resources a program uses while it's evaluated, it's
+// how an embedder stops a program that's doing too much. Each method returns
+// an Error of KindLimit or KindCanceled to stop the program, or nil to let it
+// carry on.
+type Meter interface {
+	// Step is charged for every node evaluated.
+	Step() E
+	// Call is charged for every call of a user defined function, and Return
+	// once the call has returned.
+	Call() E
+	Return()
+	// Loop is charged for every time a loop goes round, including the calls in
+	// tail position that replace the call they're in.
+	Loop() E
+	// Alloc is charged for the approximate size in bytes of every Slice and
+	// String made.
+	Alloc(size int) E
 }
 
 func NewEnv() Env {
@@ -41,9 +80,37 @@ func NewEnv() Env {
 }
 
 func NewEnvWith(parent *Env) Env {
-	return Env{store: map[string]E{}, parent: parent}
+	return Env{store: map[string]E{}, parent: parent, importer: parent.importer, meter: parent.meter,
+		host: parent.host}
+}
+
+// WithImporter returns e with the Importer for the imports evaluated in it.
+func (e Env) WithImporter(i Importer) Env {
+	e.importer = i
+	return e
+}
+
+// Importer is what imports modules for e, or nil when it can't import.
+func (e Env) Importer() Importer { return e.importer }
+
+// WithMeter returns e with the Meter charged for what's evaluated in it.
+func (e Env) WithMeter(m Meter) Env {
+	e.meter = m
+	return e
+}
+
+// Meter is what's charged for evaluating in e, or nil when nothing is.
+func (e Env) Meter() Meter { return e.meter }
+
+// WithHost returns e with the Host of the names it doesn't bind itself.
+func (e Env) WithHost(h *Host) Env {
+	e.host = h
+	return e
 }
 
+// Host is what's looked up for the names e doesn't bind, which may be nil.
+func (e Env) Host() *Host { return e.host }
+
 func (e Env) Get(name string) (E, bool) {
 	v, ok := e.store[name]
 	if !ok && e.parent != nil {
@@ -57,6 +124,30 @@ func (e Env) Set(name string, val E) E {
 	return val
 }
 
+// Assign changes the value of name in the innermost Env it's bound in, which
+// may be one of the parents of e. It reports false when name isn't bound at
+// all.
+func (e Env) Assign(name string, val E) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.parent == nil {
+		return false
+	}
+	return e.parent.Assign(name, val)
+}
+
+// Names are the names bound directly in e, not including its parents, sorted.
+func (e Env) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for n := range e.store {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type Type uint8
 
 const (
@@ -69,6 +160,16 @@ const (
 	TypeString
 	TypeBuiltin
 	TypeSlice
+	TypeCompiledFn
+	TypeClosure
+	TypeHash
+	TypeBreak
+	TypeContinue
+	TypeFloat
+	TypeBigInt
+	TypeQuote
+	TypeMacro
+	TypeModule
 )
 
 func (t Type) String() string {
@@ -91,18 +192,81 @@ func (t Type) String() string {
 		return "Builtin"
 	case TypeSlice:
 		return "Slice"
+	case TypeCompiledFn:
+		return "CompiledFn"
+	case TypeClosure:
+		return "Closure"
+	case TypeHash:
+		return "Hash"
+	case TypeBreak:
+		return "Break"
+	case TypeContinue:
+		return "Continue"
+	case TypeFloat:
+		return "Float"
+	case TypeBigInt:
+		return "BigInt"
+	case TypeQuote:
+		return "Quote"
+	case TypeMacro:
+		return "Macro"
+	case TypeModule:
+		return "Module"
 	default:
 		return "Unknown"
 	}
 }
 
 
+// HashKey is what an entity is stored under in a Hash. Two entities with the
+// same HashKey are the same key.
+type HashKey struct {
+	Type  Type
+	Value uint64
+}
+
+// Hashable is implemented by every entity that can be used as a key of a Hash.
+type Hashable interface {
+	E
+	HashKey() HashKey
+}
+
 type Int struct {
 	Value int64
 }
 
 func (Int) Type() Type { return TypeInt }
 func (i Int) Inspect() string { return strconv.Itoa(int(i.Value)) }
+func (i Int) HashKey() HashKey { return HashKey{Type: TypeInt, Value: uint64(i.Value)} }
+
+type Float struct {
+	Value float64
+}
+
+func (Float) Type() Type { return TypeFloat }
+
+// Inspect always shows a Float with a fraction or an exponent, so that 1.0 can
+// be told apart from 1.
+func (f Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
+// HashKey of a Float with a whole value is the HashKey of the Int or BigInt it
+// equals, so that {1: "a"}[1.0] finds the pair just as 1 == 1.0 is true.
+func (f Float) HashKey() HashKey {
+	switch {
+	case math.IsInf(f.Value, 0) || f.Value != math.Trunc(f.Value):
+		return HashKey{Type: TypeFloat, Value: math.Float64bits(f.Value)}
+	case f.Value >= math.MinInt64 && f.Value < math.MaxInt64:
+		return Int{Value: int64(f.Value)}.HashKey()
+	}
+	v, _ := big.NewFloat(f.Value).Int(nil)
+	return BigInt{Value: v}.HashKey()
+}
 
 type Bool struct {
 	Value bool
@@ -110,6 +274,12 @@ type Bool struct {
 
 func (Bool) Type() Type { return TypeBool }
 func (b Bool) Inspect() string { return strconv.FormatBool(b.Value) }
+func (b Bool) HashKey() HashKey {
+	if b.Value {
+		return HashKey{Type: TypeBool, Value: 1}
+	}
+	return HashKey{Type: TypeBool}
+}
 
 type Null struct{}
 
@@ -123,14 +293,28 @@ type Return struct {
 func (Return) Type() Type { return TypeReturn }
 func (r Return) Inspect() string { return r.Value.Inspect() }
 
-type Error struct {
-	Message string
+// Break is like Return but for a break statement, it's passed up through the
+// blocks it's in until it reaches the loop with its Label, or the innermost
+// loop when there's no Label.
+type Break struct {
+	Label string
+}
+
+func (Break) Type() Type      { return TypeBreak }
+func (Break) Inspect() string { return "break" }
+
+// Continue is like Break but for a continue statement.
+type Continue struct {
+	Label string
 }
 
-func (Error) Type() Type { return TypeError }
-func (e Error) Inspect() string { return "ERROR: " + e.Message }
+func (Continue) Type() Type      { return TypeContinue }
+func (Continue) Inspect() string { return "continue" }
 
 type Fn struct {
+	// Name is the name the function was first bound to, it's only used to
+	// describe the function in an Error's Stack.
+	Name string
 	Params []ast.Ident
 	Body ast.BlockStmt
 	Env Env
@@ -151,6 +335,60 @@ func (f Fn) Inspect() string {
 	return out.String()
 }
 
+// Quote is an unevaluated piece of the program, it's what quote returns and
+// what a Macro is expected to return.
+type Quote struct {
+	Node ast.Node
+}
+
+func (Quote) Type() Type { return TypeQuote }
+func (q Quote) Inspect() string {
+	if q.Node == nil {
+		return "QUOTE()"
+	}
+	return "QUOTE(" + q.Node.String() + ")"
+}
+
+// Macro is like a Fn but it's called while the program is being expanded, with
+// its arguments as Quotes, and the Quote it returns replaces the call.
+type Macro struct {
+	Params []ast.Ident
+	Body ast.BlockStmt
+	Env Env
+}
+
+func (Macro) Type() Type { return TypeMacro }
+func (m Macro) Inspect() string {
+	params := make([]string, len(m.Params))
+	for i, p := range m.Params {
+		params[i] = p.String()
+	}
+	return "macro(" + strings.Join(params, ", ") + ") " + m.Body.String()
+}
+
+// Module is the namespace of an imported module, Exports being its exported
+// bindings by name.
+type Module struct {
+	Name    string
+	Exports map[string]E
+}
+
+func (Module) Type() Type        { return TypeModule }
+func (m Module) Inspect() string { return "module " + strconv.Quote(m.Name) }
+
+// Get is the export of m called by the String name.
+func (m Module) Get(name E) E {
+	s, ok := name.(String)
+	if !ok {
+		return NewError(KindIndex, "module member must be a String, got %s", name.Type())
+	}
+	v, ok := m.Exports[s.Value]
+	if !ok {
+		return NewError(KindIndex, "module %q has no export %s", m.Name, s.Value)
+	}
+	return v
+}
+
 type String struct {
 	Value string
 }
@@ -158,6 +396,26 @@ type String struct {
 func (String) Type() Type { return TypeString }
 func (s String) Inspect() string { return s.Value }
 
+// Char is the character at index i of s, counted in runes rather than bytes.
+// It reports false when s doesn't have that many characters.
+func (s String) Char(i int64) (String, bool) {
+	if i < 0 {
+		return String{}, false
+	}
+	for _, r := range s.Value {
+		if i == 0 {
+			return String{Value: string(r)}, true
+		}
+		i--
+	}
+	return String{}, false
+}
+func (s String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: TypeString, Value: h.Sum64()}
+}
+
 type Builtin struct {
 	Fn BuiltinFn
 }
@@ -181,3 +439,171 @@ func (s Slice) Inspect() string {
 	out.WriteString("]")
 	return out.String()
 }
+
+// CompiledFn is a function that has been compiled to bytecode for the VM.
+type CompiledFn struct {
+	// Name is what the function was bound to with let, empty when it's
+	// anonymous.
+	Name         string
+	Instructions code.Instructions
+	// NumLocals is how many local bindings, including parameters, the function
+	// needs room for on the stack.
+	NumLocals int
+	NumParams int
+	// Locals are the names of the local bindings by index, and Free those of
+	// the free variables, for the errors about using them before they're set.
+	Locals, Free []string
+	// Spans are where the Instructions came from, for the positions of errors.
+	Spans code.Spans
+}
+
+func (CompiledFn) Type() Type { return TypeCompiledFn }
+func (f CompiledFn) Inspect() string {
+	return fmt.Sprintf("CompiledFn[%p]", f.Instructions)
+}
+
+// Closure is a CompiledFn with the free variables it captured when it was
+// created. Every function the VM runs is wrapped in a Closure.
+type Closure struct {
+	Fn   CompiledFn
+	Free []E
+}
+
+func (Closure) Type() Type { return TypeClosure }
+func (c Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c.Fn.Instructions)
+}
+
+// HashPair keeps the original key next to its value so a Hash can be printed
+// and iterated.
+type HashPair struct {
+	Key   E
+	Value E
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func (Hash) Type() Type { return TypeHash }
+
+// Inspect prints the pairs in the order of Sorted so the output is stable.
+func (h Hash) Inspect() string {
+	var out bytes.Buffer
+	pairs := make([]string, 0, len(h.Pairs))
+	for _, p := range h.Sorted() {
+		pairs = append(pairs, p.Key.Inspect()+": "+p.Value.Inspect())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// Promote converts an Int or a BigInt to a Float when the other of a and b is
+// a Float, and an Int to a BigInt when the other is a BigInt, so that
+// arithmetic and comparisons between the two are done as the wider type.
+// Anything else is returned as it is.
+func Promote(a, b E) (E, E) {
+	switch a.(type) {
+	case Int, BigInt:
+		switch b.(type) {
+		case Float:
+			return Float{Value: toFloat(a)}, b
+		case Int, BigInt:
+			if a.Type() != b.Type() {
+				return BigInt{Value: toBig(a)}, BigInt{Value: toBig(b)}
+			}
+		}
+	case Float:
+		switch b.(type) {
+		case Int, BigInt:
+			return a, Float{Value: toFloat(b)}
+		}
+	}
+	return a, b
+}
+
+// SetIndex changes the element of a Slice or the value under a key of a Hash.
+// Both share their elements with every copy of them so the change is seen
+// everywhere they're bound. It returns an Error when the Slice doesn't have
+// the index or left can't be changed, otherwise nil.
+func SetIndex(left, idx, val E) E {
+	switch left := left.(type) {
+	case Slice:
+		i, ok := idx.(Int)
+		if !ok {
+			return NewError(KindIndex, "slice index must be Int, got %s", idx.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Values)) {
+			return NewError(KindIndex, "index %d out of range for Slice of length %d",
+				i.Value, len(left.Values))
+		}
+		left.Values[i.Value] = val
+		return nil
+	case Hash:
+		k, ok := idx.(Hashable)
+		if !ok {
+			return NewError(KindIndex, "unusable as hash key: %s", idx.Type())
+		}
+		left.Pairs[k.HashKey()] = HashPair{Key: k, Value: val}
+		return nil
+	default:
+		return NewError(KindIndex, "index assignment not supported for %s", left.Type())
+	}
+}
+
+// Elements are what a for loop iterates over in e: the values of a Slice, the
+// characters (runes, not bytes) of a String or the keys of a Hash in the order
+// of Sorted. It reports false when e can't be iterated over.
+func Elements(e E) ([]E, bool) {
+	switch e := e.(type) {
+	case Slice:
+		return e.Values, true
+	case String:
+		vals := make([]E, 0, len(e.Value))
+		for _, r := range e.Value {
+			vals = append(vals, String{Value: string(r)})
+		}
+		return vals, true
+	case Hash:
+		pairs := e.Sorted()
+		vals := make([]E, len(pairs))
+		for i, p := range pairs {
+			vals[i] = p.Key
+		}
+		return vals, true
+	default:
+		return nil, false
+	}
+}
+
+// Sorted are the pairs of h ordered by the type of their key and then by the
+// key itself.
+func (h Hash) Sorted() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, p := range h.Pairs {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+		switch a := a.(type) {
+		case Int:
+			return a.Value < b.(Int).Value
+		case Float:
+			return a.Value < b.(Float).Value
+		case BigInt:
+			return a.Value.Cmp(b.(BigInt).Value) < 0
+		case String:
+			return a.Value < b.(String).Value
+		case Bool:
+			return !a.Value && b.(Bool).Value
+		default:
+			return a.Inspect() < b.Inspect()
+		}
+	})
+	return pairs
+}
@@ -0,0 +1,118 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+
+	"mmm/token"
+)
+
+// ErrorKind classifies an Error so that callers can tell what went wrong
+// without having to pick apart its message.
+type ErrorKind uint8
+
+const (
+	// KindOther is every error that doesn't fit one of the other kinds.
+	KindOther ErrorKind = iota
+	// KindType is an operator or function used with types it doesn't support.
+	KindType
+	// KindUnknownIdent is an identifier that isn't bound to anything.
+	KindUnknownIdent
+	// KindArity is a function called with the wrong number of arguments.
+	KindArity
+	// KindIndex is indexing something that can't be indexed, or with a key
+	// that can't be used.
+	KindIndex
+	// KindDivByZero is dividing by zero.
+	KindDivByZero
+	// KindLimit is a program going over one of the limits it's evaluated with.
+	KindLimit
+	// KindCanceled is a program stopped by the context it was evaluated with
+	// being canceled or going past its deadline.
+	KindCanceled
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindType:
+		return "type mismatch"
+	case KindUnknownIdent:
+		return "unknown identifier"
+	case KindArity:
+		return "arity"
+	case KindIndex:
+		return "index"
+	case KindDivByZero:
+		return "division by zero"
+	case KindLimit:
+		return "limit exceeded"
+	case KindCanceled:
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// Error is a runtime error. It's an entity so that it can be passed around
+// like any other value until it reaches the top of the program.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	// Pos and End are the span of the source that failed, they're unknown for
+	// errors that haven't been attached to an [ast.Node] yet.
+	Pos, End token.Pos
+	// Stack are the function calls that were running when the error happened,
+	// the innermost first.
+	Stack []Frame
+}
+
+// Frame is a single function call in the Stack of an Error.
+type Frame struct {
+	// Fn is the name the function was bound to, empty when it's anonymous.
+	Fn string
+	// Pos is where the function was called.
+	Pos token.Pos
+}
+
+func (f Frame) String() string {
+	name := f.Fn
+	if name == "" {
+		name = "fn"
+	}
+	if !f.Pos.IsValid() {
+		return "in " + name
+	}
+	return "in " + name + ", called at " + f.Pos.String()
+}
+
+// NewError creates an Error of kind with a formatted message.
+func NewError(kind ErrorKind, format string, a ...any) Error {
+	return Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// Errorf creates an Error of KindOther with a formatted message.
+func Errorf(format string, a ...any) Error {
+	return NewError(KindOther, format, a...)
+}
+
+func (Error) Type() Type        { return TypeError }
+func (e Error) Inspect() string { return "ERROR: " + e.Error() }
+
+// Error renders the Error as file:line:col: message.
+func (e Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return e.Pos.String() + ": " + e.Message
+}
+
+// Traceback is the Error followed by one line per Frame of its Stack.
+func (e Error) Traceback() string {
+	var b strings.Builder
+	b.WriteString(e.Error())
+	for _, f := range e.Stack {
+		b.WriteString("\n\t")
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
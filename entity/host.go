@@ -0,0 +1,68 @@
+package entity
+
+import (
+	"io"
+	"sort"
+)
+
+// Host is the Go functions and constants a program embedding mmm gives the
+// programs it runs, on top of the Builtins. They're looked up after the
+// bindings of a program, so a program can shadow them, and before the
+// Builtins, so a Host can replace those.
+//
+//	h := &entity.Host{}
+//	h.Const("version", entity.String{Value: "1.0"})
+//	h.Func("env", func(args ...entity.E) entity.E {
+//		if err := entity.CheckArgs("env", args, entity.TypeString); err != nil {
+//			return err
+//		}
+//		return entity.String{Value: os.Getenv(args[0].(entity.String).Value)}
+//	})
+//
+// The zero Host has nothing in it, as does a nil *Host.
+type Host struct {
+	defs map[string]E
+}
+
+// Func registers fn as the function called name, replacing anything already
+// registered as name. fn should check its arguments with CheckArgs, or
+// CheckArity and CheckType, so its errors read like those of the Builtins.
+func (h *Host) Func(name string, fn BuiltinFn) { h.Const(name, Builtin{Fn: fn}) }
+
+// Const registers v as the value called name, replacing anything already
+// registered as name.
+func (h *Host) Const(name string, v E) {
+	if h.defs == nil {
+		h.defs = map[string]E{}
+	}
+	h.defs[name] = v
+}
+
+// Output registers puts and print writing to w instead of os.Stdout, so that
+// the output of every program run with h goes to w.
+func (h *Host) Output(w io.Writer) {
+	h.Func("puts", Puts(w))
+	h.Func("print", Print(w))
+}
+
+// Get returns what's registered as name.
+func (h *Host) Get(name string) (E, bool) {
+	if h == nil {
+		return nil, false
+	}
+	v, ok := h.defs[name]
+	return v, ok
+}
+
+// Names are the names registered, sorted.
+func (h *Host) Names() []string {
+	if h == nil {
+		return nil
+	}
+	names := make([]string, 0, len(h.defs))
+	for n := range h.defs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
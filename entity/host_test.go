@@ -0,0 +1,54 @@
+package entity_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"mmm/entity"
+	"mmm/is"
+)
+
+func TestHost(t *testing.T) {
+	t.Parallel()
+	h := &entity.Host{}
+	h.Const("answer", entity.Int{Value: 42})
+	h.Func("double", func(args ...entity.E) entity.E {
+		if err := entity.CheckArgs("double", args, entity.TypeInt); err != nil {
+			return err
+		}
+		return entity.Int{Value: args[0].(entity.Int).Value * 2}
+	})
+	h.Const("answer", entity.Int{Value: 43})
+
+	v, ok := h.Get("answer")
+	is.Equal(t, true, ok)
+	is.Equal(t, "43", v.Inspect())
+	v, ok = h.Get("double")
+	is.Equal(t, true, ok)
+	is.Equal(t, "4", v.(entity.Builtin).Fn(entity.Int{Value: 2}).Inspect())
+	is.Equal(t, "ERROR: argument 1 to `double` must be Int, got Bool",
+		v.(entity.Builtin).Fn(entity.Bool{}).Inspect())
+	_, ok = h.Get("nope")
+	is.Equal(t, false, ok)
+	is.Equal(t, "[answer double]", fmt.Sprint(h.Names()))
+
+	var none *entity.Host
+	_, ok = none.Get("answer")
+	is.Equal(t, false, ok)
+	is.Equal(t, 0, len(none.Names()))
+}
+
+func TestHost_Output(t *testing.T) {
+	t.Parallel()
+	var a, b bytes.Buffer
+	ha, hb := &entity.Host{}, &entity.Host{}
+	ha.Output(&a)
+	hb.Output(&b)
+	puts, _ := ha.Get("puts")
+	puts.(entity.Builtin).Fn(entity.Int{Value: 1})
+	print, _ := hb.Get("print")
+	print.(entity.Builtin).Fn(entity.Int{Value: 2}, entity.Int{Value: 3})
+	is.Equal(t, "1\n", a.String())
+	is.Equal(t, "2 3", b.String())
+}
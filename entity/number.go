@@ -0,0 +1,199 @@
+package entity
+
+import (
+	"hash/fnv"
+	"math"
+	"math/big"
+)
+
+// BigInt is an integer that doesn't fit in an Int. Arithmetic on Ints that
+// would overflow gives a BigInt instead, and arithmetic on BigInts that fits
+// in an int64 gives an Int again, so the two never hold the same value.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (BigInt) Type() Type        { return TypeBigInt }
+func (b BigInt) Inspect() string { return b.Value.String() }
+func (b BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write(b.Value.Bytes())
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	return HashKey{Type: TypeBigInt, Value: h.Sum64()}
+}
+
+// NewInt is v as an Int when it fits in an int64, otherwise as a BigInt.
+func NewInt(v *big.Int) E {
+	if v.IsInt64() {
+		return Int{Value: v.Int64()}
+	}
+	return BigInt{Value: v}
+}
+
+// maxShift is the biggest shift count allowed for <<, it stops a small
+// program from asking for an enormous BigInt.
+const maxShift = 1 << 20
+
+// IntOp applies the arithmetic or bitwise operator op, one of + - * / % & | ^
+// << or >>, to a and b which are each an Int or a BigInt. Division and % truncate
+// towards zero like Go, and dividing by zero is an Error. The shift count b
+// mustn't be negative.
+func IntOp(op string, a, b E) E {
+	if x, ok := a.(Int); ok {
+		if y, ok := b.(Int); ok {
+			if v, ok := int64Op(op, x.Value, y.Value); ok {
+				return Int{Value: v}
+			}
+		}
+	}
+	x, y := toBig(a), toBig(b)
+	switch op {
+	case "/", "%":
+		if y.Sign() == 0 {
+			return NewError(KindDivByZero, "division by zero")
+		}
+	case "<<", ">>":
+		if y.Sign() < 0 {
+			return Errorf("negative shift count: %s", b.Inspect())
+		}
+		if op == "<<" && y.Cmp(big.NewInt(maxShift)) > 0 {
+			return Errorf("shift count too large: %s", b.Inspect())
+		}
+	}
+	switch op {
+	case "+":
+		return NewInt(new(big.Int).Add(x, y))
+	case "-":
+		return NewInt(new(big.Int).Sub(x, y))
+	case "*":
+		return NewInt(new(big.Int).Mul(x, y))
+	case "/":
+		return NewInt(new(big.Int).Quo(x, y))
+	case "%":
+		return NewInt(new(big.Int).Rem(x, y))
+	case "&":
+		return NewInt(new(big.Int).And(x, y))
+	case "|":
+		return NewInt(new(big.Int).Or(x, y))
+	case "^":
+		return NewInt(new(big.Int).Xor(x, y))
+	case "<<":
+		return NewInt(new(big.Int).Lsh(x, uint(y.Uint64())))
+	case ">>":
+		if !y.IsUint64() {
+			// Shifting by more bits than x could ever have.
+			return NewInt(big.NewInt(int64(x.Sign() >> 1)))
+		}
+		return NewInt(new(big.Int).Rsh(x, uint(y.Uint64())))
+	default:
+		return NewError(KindType, "unknown operator: %s %s %s", a.Type(), op, b.Type())
+	}
+}
+
+// int64Op is op on x and y, it reports false when the result doesn't fit in an
+// int64 or op can't be done on them, e.g. division by zero, so that IntOp can
+// deal with it.
+func int64Op(op string, x, y int64) (int64, bool) {
+	switch op {
+	case "+":
+		s := x + y
+		return s, (s > x) == (y > 0)
+	case "-":
+		d := x - y
+		return d, (d < x) == (y > 0)
+	case "*":
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+		if (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return 0, false
+		}
+		p := x * y
+		return p, p/y == x
+	case "/":
+		if y == 0 || (x == math.MinInt64 && y == -1) {
+			return 0, false
+		}
+		return x / y, true
+	case "%":
+		if y == 0 {
+			return 0, false
+		}
+		return x % y, true
+	case "&":
+		return x & y, true
+	case "|":
+		return x | y, true
+	case "^":
+		return x ^ y, true
+	case "<<":
+		if y < 0 || y >= 63 {
+			return 0, false
+		}
+		v := x << y
+		return v, v>>y == x
+	case ">>":
+		if y < 0 {
+			return 0, false
+		}
+		if y >= 63 {
+			return x >> 63, true
+		}
+		return x >> y, true
+	default:
+		return 0, false
+	}
+}
+
+// NegInt is -e for an Int or a BigInt.
+func NegInt(e E) E {
+	if i, ok := e.(Int); ok && i.Value != math.MinInt64 {
+		return Int{Value: -i.Value}
+	}
+	return NewInt(new(big.Int).Neg(toBig(e)))
+}
+
+// CompareInts compares a and b, which are each an Int or a BigInt, returning
+// -1, 0 or 1 when a is less than, equal to or greater than b.
+func CompareInts(a, b E) int {
+	if x, ok := a.(Int); ok {
+		if y, ok := b.(Int); ok {
+			switch {
+			case x.Value < y.Value:
+				return -1
+			case x.Value > y.Value:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	return toBig(a).Cmp(toBig(b))
+}
+
+func toBig(e E) *big.Int {
+	switch e := e.(type) {
+	case Int:
+		return big.NewInt(e.Value)
+	case BigInt:
+		return e.Value
+	default:
+		return new(big.Int)
+	}
+}
+
+func toFloat(e E) float64 {
+	switch e := e.(type) {
+	case Int:
+		return float64(e.Value)
+	case BigInt:
+		f, _ := new(big.Float).SetInt(e.Value).Float64()
+		return f
+	case Float:
+		return e.Value
+	default:
+		return 0
+	}
+}
@@ -1,34 +1,42 @@
 package eval
 
 import (
-	"fmt"
+	"math"
+	"strings"
+
 	"mmm/ast"
 	"mmm/entity"
+	"mmm/token"
 )
 
 var (
-	builtins = map[string]entity.Builtin{
-		"len": {Fn: func(e ...entity.E) entity.E {
-			if len(e) != 1 {
-				return newErr("len only accepts one argument.")
-			}
-			switch v := e[0].(type) {
-			case entity.String:
-				return entity.Int{Value: int64(len(v.Value))}
-			case entity.Slice:
-				return entity.Int{Value: int64(len(v.Values))}
-			default:
-				return newErr("argument to `len` not supported, got %s", v.Type())
-			}
-			},
-		},
-	}
 	_true = entity.Bool{Value: true}
 	_false = entity.Bool{Value: false}
 	null = entity.Null{}
 )
 
+// Eval evaluates node in env. Any [entity.Error] produced is positioned at the
+// innermost node that failed and carries the user defined functions that were
+// being called when it happened.
 func Eval(node ast.Node, env entity.Env) entity.E {
+	if m := env.Meter(); m != nil {
+		if err := m.Step(); err != nil {
+			return at(node, err)
+		}
+	}
+	return at(node, eval(node, env))
+}
+
+// at positions e at node when it's an Error that hasn't been positioned yet.
+func at(node ast.Node, e entity.E) entity.E {
+	if err, ok := e.(entity.Error); ok && !err.Pos.IsValid() && node != nil {
+		err.Pos, err.End = node.Pos(), node.End()
+		return err
+	}
+	return e
+}
+
+func eval(node ast.Node, env entity.Env) entity.E {
 	switch node := node.(type) {
 	// Statements
 	case ast.Program:
@@ -36,18 +44,38 @@ func Eval(node ast.Node, env entity.Env) entity.E {
 	case ast.ExprStmt:
 		return Eval(node.Expression(), env)
 	case ast.BlockStmt:
-		return evalBlock(node, env)
+		return evalBlock(node, env, false)
 	case ast.RetStmt:
-		v := Eval(node.Value(), env)
-		if isErr(v) {
+		var v entity.E
+		if call, ok := node.Value().(ast.CallExpr); ok {
+			v = at(call, evalCall(call, env, true))
+		} else {
+			v = Eval(node.Value(), env)
+		}
+		if interrupted(v) {
 			return v
 		}
 		return entity.Return{Value: v}
+	case ast.AssignStmt:
+		return evalAssign(node, env)
+	case ast.WhileStmt:
+		return evalWhile(node, env)
+	case ast.ForStmt:
+		return evalFor(node, env)
+	case ast.BranchStmt:
+		if node.Tok() == token.TypeBreak {
+			return entity.Break{Label: node.Label}
+		}
+		return entity.Continue{Label: node.Label}
 	case ast.LetStmt:
 		val := Eval(node.Value(), env)
-		if isErr(val) {
+		if interrupted(val) {
 			return val
 		}
+		if fn, ok := val.(entity.Fn); ok && fn.Name == "" {
+			fn.Name = node.Name()
+			val = fn
+		}
 		env.Set(node.Name(), val)
 		return nil
 	// Expressions
@@ -57,60 +85,88 @@ func Eval(node ast.Node, env entity.Env) entity.E {
 		}
 		return _false
 	case ast.Integer:
+		if v := node.Big(); v != nil {
+			return entity.BigInt{Value: v}
+		}
 		return entity.Int{Value: node.Value()}
+	case ast.Float:
+		return entity.Float{Value: node.Value()}
 	case ast.PrefixExpr:
 		v := Eval(node.Right(), env)
-		if isErr(v) {
+		if interrupted(v) {
 			return v
 		}
 		return evalPrefix(node.Operator(), v)
 	case ast.InfixExpr:
 		l := Eval(node.Left(), env)
-		if isErr(l) {
+		if interrupted(l) {
 			return l
 		}
+		switch op := node.Operator(); {
+		case op == "&&" && !isTruthy(l):
+			return _false
+		case op == "||" && isTruthy(l):
+			return _true
+		case op == "&&" || op == "||":
+			r := Eval(node.Right(), env)
+			if interrupted(r) {
+				return r
+			}
+			return staticBool(isTruthy(r))
+		}
 		r := Eval(node.Right(), env)
-		if isErr(r) {
+		if interrupted(r) {
 			return r
 		}
-		return evalInfix(l, node.Operator(), r)
+		return alloc(env.Meter(), evalInfix(l, node.Operator(), r))
 	case ast.IfExpr:
-		return evalIf(node, env)
+		return evalIf(node, env, false)
 	case ast.Ident:
 		if val, ok := env.Get(node.String()); ok {
 			return val
 		}
-		if b, ok := builtins[node.String()]; ok {
+		if val, ok := env.Host().Get(node.String()); ok {
+			return val
+		}
+		if b, ok := entity.GetBuiltin(node.String()); ok {
 			return b
 		}
-		return newErr("identifier not found: " + node.String())
+		return newErr(entity.KindUnknownIdent, "identifier not found: %s", node.String())
 	case ast.Function:
 		return entity.Fn{Env: env, Params: node.Params, Body: node.Body}
-	case ast.CallExpr:
-		fn := Eval(node.Fn, env)
-		if isErr(fn) {
-			return fn
+	case ast.Import:
+		imp := env.Importer()
+		if imp == nil {
+			return newErr(entity.KindOther, "cannot import %q: imports are not enabled", node.Path())
 		}
-		args := evalExpressions(node.Args, env)
-		if len(args) == 1 && isErr(args[0]) {
-			return args[0]
+		res := imp.Import(node.Path(), env)
+		if err, ok := res.(entity.Error); ok && err.Pos.IsValid() {
+			// The error happened in the module, show where it was imported.
+			err.Stack = append(err.Stack, entity.Frame{Fn: node.String(), Pos: node.Pos()})
+			return err
 		}
-		return evalFn(fn, args)
+		return res
+	case ast.MacroLiteral:
+		return entity.Macro{Params: node.Params, Body: node.Body, Env: env}
+	case ast.CallExpr:
+		return evalCall(node, env, false)
 	case ast.String:
-		return entity.String{Value: node.String()}
+		return alloc(env.Meter(), entity.String{Value: node.String()})
 	case ast.Slice:
 		vals := evalExpressions(node.Values(), env)
-		if len(vals) == 1 && isErr(vals[0]) {
+		if len(vals) == 1 && interrupted(vals[0]) {
 			return vals[0]
 		}
-		return entity.Slice{Values: vals}
+		return alloc(env.Meter(), entity.Slice{Values: vals})
+	case ast.Hash:
+		return evalHash(node, env)
 	case ast.Index:
 		l := Eval(node.Left(), env)
-		if isErr(l) {
+		if interrupted(l) {
 			return l
 		}
 		i := Eval(node.Idx(), env)
-		if isErr(i) {
+		if interrupted(i) {
 			return i
 		}
 		return evalIndex(l, i)
@@ -132,6 +188,9 @@ func evalProgram(ss []ast.Statement, env entity.Env) entity.E {
 		res = Eval(s, env)
 		switch res := res.(type) {
 		case entity.Return:
+			if tc, ok := res.Value.(tailCall); ok {
+				return tc.call()
+			}
 			return res.Value
 		case entity.Error:
 			return res
@@ -154,75 +213,260 @@ func evalPrefix(op string, right entity.E) entity.E {
 			return _false
 		}
 	case "-":
-		if right.Type() != entity.TypeInt {
-			return newErr("unknown operator: -%s", right.Type())
+		switch right := right.(type) {
+		case entity.Int, entity.BigInt:
+			return entity.NegInt(right)
+		case entity.Float:
+			return entity.Float{Value: -right.Value}
+		default:
+			return newErr(entity.KindType, "unknown operator: -%s", right.Type())
 		}
-		return entity.Int{Value: -right.(entity.Int).Value}
 	default:
-		return newErr("unknown operator: %s%s", op, right.Type())
+		return newErr(entity.KindType, "unknown operator: %s%s", op, right.Type())
 	}
 }
 
 func evalInfix(left entity.E, op string, right entity.E) entity.E {
+	left, right = entity.Promote(left, right)
 	if left.Type() != right.Type() {
-		return newErr("type mismatch: %s %s %s", left.Type(), op, right.Type())
+		return newErr(entity.KindType, "type mismatch: %s %s %s", left.Type(), op, right.Type())
 	}
-	if left.Type() == entity.TypeInt && right.Type() == entity.TypeInt {
+	if left.Type() == entity.TypeInt || left.Type() == entity.TypeBigInt {
 		return evalIntInfix(left, op, right)
 	}
+	if left.Type() == entity.TypeFloat {
+		return evalFloatInfix(left, op, right)
+	}
 	if left.Type() == entity.TypeString && right.Type() == entity.TypeString {
 	if op != "+" {
-		return newErr("unknown operator: %s %s %s", left.Type(), op, right.Type())
+		return newErr(entity.KindType, "unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
 	return entity.String{Value: left.(entity.String).Value + right.(entity.String).Value}
 	}
-	switch op {
-	case "==":
+	// Only Bools and Null compare with == and != here, the other entities
+	// may not be comparable in Go, like a Hash or a Fn.
+	switch t := left.Type(); {
+	case op == "==" && (t == entity.TypeBool || t == entity.TypeNull):
 		return staticBool(left == right)
-	case "!=":
+	case op == "!=" && (t == entity.TypeBool || t == entity.TypeNull):
 		return staticBool(left != right)
 	default:
-		return newErr("unknown operator: %s %s %s", left.Type(), op, right.Type())
+		return newErr(entity.KindType, "unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
 }
 
 func evalIntInfix(left entity.E, op string, right entity.E) entity.E {
-	lval, rval := left.(entity.Int).Value, right.(entity.Int).Value
+	switch op {
+	case "+", "-", "*", "/", "%", "&", "|", "^", "<<", ">>":
+		return entity.IntOp(op, left, right)
+	case "<":
+		return staticBool(entity.CompareInts(left, right) < 0)
+	case ">":
+		return staticBool(entity.CompareInts(left, right) > 0)
+	case "<=":
+		return staticBool(entity.CompareInts(left, right) <= 0)
+	case ">=":
+		return staticBool(entity.CompareInts(left, right) >= 0)
+	case "==":
+		return staticBool(entity.CompareInts(left, right) == 0)
+	case "!=":
+		return staticBool(entity.CompareInts(left, right) != 0)
+	default:
+		return newErr(entity.KindType, "unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
+}
+
+func evalFloatInfix(left entity.E, op string, right entity.E) entity.E {
+	lval, rval := left.(entity.Float).Value, right.(entity.Float).Value
 	switch op {
 	case "+":
-		return entity.Int{Value: lval + rval}
+		return entity.Float{Value: lval + rval}
 	case "-":
-		return entity.Int{Value: lval - rval}
+		return entity.Float{Value: lval - rval}
 	case "/":
-		return entity.Int{Value: lval / rval}
+		if rval == 0 {
+			return newErr(entity.KindDivByZero, "division by zero")
+		}
+		return entity.Float{Value: lval / rval}
+	case "%":
+		if rval == 0 {
+			return newErr(entity.KindDivByZero, "division by zero")
+		}
+		return entity.Float{Value: math.Mod(lval, rval)}
 	case "*":
-		return entity.Int{Value: lval * rval}
+		return entity.Float{Value: lval * rval}
 	case "<":
 		return staticBool(lval < rval)
 	case ">":
 		return staticBool(lval > rval)
+	case "<=":
+		return staticBool(lval <= rval)
+	case ">=":
+		return staticBool(lval >= rval)
 	case "==":
 		return staticBool(lval == rval)
 	case "!=":
 		return staticBool(lval != rval)
 	default:
-		return newErr("unknown operator: %s %s %s", left.Type(), op, right.Type())
+		return newErr(entity.KindType, "unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
 }
 
-func evalIf(ife ast.IfExpr, env entity.Env) entity.E {
+// evalIf evaluates ife, tail is set when ife is in tail position, see
+// evalTail.
+func evalIf(ife ast.IfExpr, env entity.Env, tail bool) entity.E {
 	c := Eval(ife.Condition, env)
-	if isErr(c) {
+	if interrupted(c) {
 		return c
 	}
+	var blk ast.BlockStmt
 	switch {
 	case isTruthy(c):
-		return Eval(ife.Consequence, env)
+		blk = ife.Consequence
 	case ife.Alternative.OK():
-		return Eval(ife.Alternative, env)
+		blk = ife.Alternative
 	default:
 		return null
 	}
+	var res entity.E
+	if tail {
+		res = at(blk, evalBlock(blk, env, true))
+	} else {
+		res = Eval(blk, env)
+	}
+	if res == nil {
+		return null
+	}
+	return res
+}
+
+// evalAssign evaluates the parts of the target before the value, a compound
+// assignment like x += 1 is evaluated as x = x + 1.
+func evalAssign(a ast.AssignStmt, env entity.Env) entity.E {
+	op := strings.TrimSuffix(a.Operator(), "=")
+	switch target := a.Target.(type) {
+	case ast.Ident:
+		name := target.String()
+		cur, ok := env.Get(name)
+		if !ok {
+			return newErr(entity.KindUnknownIdent,
+				"assignment to undeclared identifier: %s", name)
+		}
+		val := Eval(a.Value, env)
+		if interrupted(val) {
+			return val
+		}
+		if op != "" {
+			if val = alloc(env.Meter(), evalInfix(cur, op, val)); interrupted(val) {
+				return val
+			}
+		}
+		if fn, ok := val.(entity.Fn); ok && fn.Name == "" {
+			fn.Name = name
+			val = fn
+		}
+		env.Assign(name, val)
+		return nil
+	case ast.Index:
+		left := Eval(target.Left(), env)
+		if interrupted(left) {
+			return left
+		}
+		idx := Eval(target.Idx(), env)
+		if interrupted(idx) {
+			return idx
+		}
+		val := Eval(a.Value, env)
+		if interrupted(val) {
+			return val
+		}
+		if op != "" {
+			cur := evalIndex(left, idx)
+			if interrupted(cur) {
+				return cur
+			}
+			if val = alloc(env.Meter(), evalInfix(cur, op, val)); interrupted(val) {
+				return val
+			}
+		}
+		if err := entity.SetIndex(left, idx, val); err != nil {
+			return err
+		}
+		return nil
+	default:
+		return newErr(entity.KindOther, "cannot assign to %s", a.Target)
+	}
+}
+
+// evalWhile runs the loop with a Go loop rather than recursion so that it can
+// go round any number of times.
+func evalWhile(w ast.WhileStmt, env entity.Env) entity.E {
+	for {
+		c := Eval(w.Cond, env)
+		if interrupted(c) {
+			return c
+		}
+		if !isTruthy(c) {
+			return nil
+		}
+		if err := loop(env); err != nil {
+			return err
+		}
+		if res, done := loopControl(w.Label, Eval(w.Body, env)); done {
+			return res
+		}
+	}
+}
+
+func evalFor(f ast.ForStmt, env entity.Env) entity.E {
+	iter := Eval(f.Iter, env)
+	if interrupted(iter) {
+		return iter
+	}
+	elems, ok := entity.Elements(iter)
+	if !ok {
+		return newErr(entity.KindType, "cannot iterate over %s", iter.Type())
+	}
+	for _, e := range elems {
+		if err := loop(env); err != nil {
+			return err
+		}
+		env.Set(f.Var.String(), e)
+		if res, done := loopControl(f.Label, Eval(f.Body, env)); done {
+			return res
+		}
+	}
+	return nil
+}
+
+// loop charges the Meter of env, if any, for a loop going round.
+func loop(env entity.Env) entity.E {
+	if m := env.Meter(); m != nil {
+		return m.Loop()
+	}
+	return nil
+}
+
+// loopControl decides what the loop called label does after its body
+// evaluated to res. It reports whether the loop is done along with what the
+// loop evaluates to, which is only ever a value to pass up to an outer block.
+func loopControl(label string, res entity.E) (entity.E, bool) {
+	switch res := res.(type) {
+	case entity.Break:
+		if res.Label == "" || res.Label == label {
+			return nil, true
+		}
+		return res, true
+	case entity.Continue:
+		if res.Label == "" || res.Label == label {
+			return nil, false
+		}
+		return res, true
+	case entity.Return, entity.Error:
+		return res, true
+	default:
+		return nil, false
+	}
 }
 
 func isTruthy(e entity.E) bool {
@@ -238,13 +482,19 @@ func isTruthy(e entity.E) bool {
 	}
 }
 
-func evalBlock(blk ast.BlockStmt, env entity.Env) entity.E {
+// evalBlock evaluates the statements of blk, tail is set when blk is the body
+// of a function or is in tail position in one, its last statement is then
+// evaluated by evalTail.
+func evalBlock(blk ast.BlockStmt, env entity.Env, tail bool) entity.E {
 	var res entity.E
-	for _, s := range blk.Statements {
+	for i, s := range blk.Statements {
+		if tail && i == len(blk.Statements)-1 {
+			return evalTail(s, env)
+		}
 		res = Eval(s, env)
 		if res != nil  {
 			switch res.Type() {
-			case entity.TypeReturn, entity.TypeError:
+			case entity.TypeReturn, entity.TypeError, entity.TypeBreak, entity.TypeContinue:
 				return res
 			}
 		}
@@ -252,8 +502,8 @@ func evalBlock(blk ast.BlockStmt, env entity.Env) entity.E {
 	return res
 }
 
-func newErr(format string, a ...any) entity.Error {
-	return entity.Error{Message: fmt.Sprintf(format, a...)}
+func newErr(kind entity.ErrorKind, format string, a ...any) entity.Error {
+	return entity.NewError(kind, format, a...)
 }
 
 func isErr(e entity.E) bool {
@@ -263,11 +513,23 @@ func isErr(e entity.E) bool {
 	return e.Type() == entity.TypeError
 }
 
+// interrupted reports whether e stops the evaluation of the expression it came
+// out of, to be passed up to what handles it: an Error, or the Break, Continue
+// or Return of a statement in a block used as an expression.
+func interrupted(e entity.E) bool {
+	switch e.(type) {
+	case entity.Error, entity.Break, entity.Continue, entity.Return:
+		return true
+	default:
+		return false
+	}
+}
+
 func evalExpressions(exprs []ast.Expr, env entity.Env) []entity.E {
 	var res []entity.E
 	for _, e := range exprs {
 		val := Eval(e, env)
-		if isErr(val) {
+		if interrupted(val) {
 			return []entity.E{val}
 		}
 		res = append(res, val)
@@ -275,22 +537,132 @@ func evalExpressions(exprs []ast.Expr, env entity.Env) []entity.E {
 	return res
 }
 
-func evalFn(fn entity.E, args []entity.E) entity.E {
-	switch fn := fn.(type) {
-	case entity.Fn:
-	env := entity.NewEnvWith(&fn.Env)
-	for i, p := range fn.Params {
-		env.Set(p.String(), args[i])
+// evalCall evaluates a call, tail is set when it's in tail position in which
+// case a call of an [entity.Fn] is returned as a tailCall instead of made.
+func evalCall(node ast.CallExpr, env entity.Env, tail bool) entity.E {
+	if id, ok := node.Fn.(ast.Ident); ok && id.String() == "quote" {
+		if len(node.Args) != 1 {
+			return newErr(entity.KindArity, "wrong number of arguments: want=1, got=%d",
+				len(node.Args))
+		}
+		return quote(node.Args[0], env)
 	}
-	val := Eval(fn.Body, env)
-	if ret, ok := val.(entity.Return); ok {
-		return ret.Value
+	fn := Eval(node.Fn, env)
+	if interrupted(fn) {
+		return fn
 	}
-	return val
+	args := evalExpressions(node.Args, env)
+	if len(args) == 1 && interrupted(args[0]) {
+		return args[0]
+	}
+	if f, ok := fn.(entity.Fn); ok {
+		tc := tailCall{fn: f, args: args, at: node, meter: env.Meter()}
+		if tail {
+			return tc
+		}
+		return tc.call()
+	}
+	return evalFn(fn, args, env.Meter())
+}
+
+// evalTail evaluates node, which is in tail position: its value is what the
+// function it's in returns. A call in tail position is returned as a tailCall
+// for evalFn to make.
+func evalTail(node ast.Node, env entity.Env) entity.E {
+	switch node := node.(type) {
+	case ast.ExprStmt:
+		return evalTail(node.Expression(), env)
+	case ast.CallExpr:
+		return at(node, evalCall(node, env, true))
+	case ast.IfExpr:
+		return at(node, evalIf(node, env, true))
+	default:
+		return Eval(node, env)
+	}
+}
+
+// tailCall is a call that's the last thing a function does. Rather than being
+// made while the function is being evaluated, it's returned in place of the
+// function's value and evalFn makes it. Recursion in tail position therefore
+// runs in constant Go stack.
+type tailCall struct {
+	fn   entity.Fn
+	args []entity.E
+	at   ast.CallExpr
+	// meter is the Meter of the Env the call was made in.
+	meter entity.Meter
+}
+
+func (tailCall) Type() entity.Type { return entity.TypeFn }
+func (tc tailCall) Inspect() string { return tc.at.String() }
+
+// call makes tc, see fail.
+func (tc tailCall) call() entity.E { return tc.fail(evalFn(tc.fn, tc.args, tc.meter)) }
+
+// fail adds tc to the Stack of res when it's an Error. Only the tail call that
+// failed is in the Stack, not the ones that led up to it.
+func (tc tailCall) fail(res entity.E) entity.E {
+	if err, ok := res.(entity.Error); ok {
+		err.Stack = append(err.Stack, entity.Frame{Fn: tc.fn.Name, Pos: tc.at.Pos()})
+		return at(tc.at, err)
+	}
+	return res
+}
+
+// evalFn calls fn with args, and then the tail calls it returns one after the
+// other until one of them returns a value. m, when it's not nil, is charged
+// for the call as a whole, the tail calls don't nest so they're charged like a
+// loop going round.
+func evalFn(fn entity.E, args []entity.E, m entity.Meter) entity.E {
+	if _, ok := fn.(entity.Fn); ok && m != nil {
+		if err := m.Call(); err != nil {
+			return err
+		}
+		defer m.Return()
+	}
+	res := apply(fn, args, m)
+	for {
+		tc, ok := res.(tailCall)
+		if !ok {
+			return res
+		}
+		if m != nil {
+			if err := m.Loop(); err != nil {
+				return tc.fail(err)
+			}
+		}
+		if res = tc.fail(apply(tc.fn, tc.args, m)); isErr(res) {
+			return res
+		}
+	}
+}
+
+// apply calls fn with args, a tailCall is returned as is. The body of fn is
+// evaluated with m rather than the Meter of the Env it was defined in.
+func apply(fn entity.E, args []entity.E, m entity.Meter) entity.E {
+	switch fn := fn.(type) {
+	case entity.Fn:
+		if len(args) != len(fn.Params) {
+			return newErr(entity.KindArity, "wrong number of arguments: want=%d, got=%d",
+				len(fn.Params), len(args))
+		}
+		env := entity.NewEnvWith(&fn.Env).WithMeter(m)
+		for i, p := range fn.Params {
+			env.Set(p.String(), args[i])
+		}
+		val := evalBlock(fn.Body, env, true)
+		if ret, ok := val.(entity.Return); ok {
+			val = ret.Value
+		}
+		if val == nil {
+			// The body is empty or ends with a statement.
+			return null
+		}
+		return val
 	case entity.Builtin:
-		return fn.Fn(args...)
+		return alloc(m, fn.Fn(args...))
 	default:
-		return newErr("not a function: %s", fn.Type())
+		return newErr(entity.KindType, "not a function: %s", fn.Type())
 	}
 }
 
@@ -306,7 +678,49 @@ func evalIndex(left, idx entity.E) entity.E {
 				return null
 			}
 			return left.Values[i]
+		case entity.TypeString:
+			i, ok := idx.(entity.Int)
+			if !ok {
+				return null
+			}
+			c, ok := left.(entity.String).Char(i.Value)
+			if !ok {
+				return null
+			}
+			return c
+		case entity.TypeModule:
+			return left.(entity.Module).Get(idx)
+		case entity.TypeHash:
+			k, ok := idx.(entity.Hashable)
+			if !ok {
+				return newErr(entity.KindIndex, "unusable as hash key: %s", idx.Type())
+			}
+			p, ok := left.(entity.Hash).Pairs[k.HashKey()]
+			if !ok {
+				return null
+			}
+			return p.Value
 		default:
-			return newErr("index operator not supported for %s", left.Type())
+			return newErr(entity.KindIndex, "index operator not supported for %s", left.Type())
+	}
+}
+
+func evalHash(h ast.Hash, env entity.Env) entity.E {
+	pairs := make(map[entity.HashKey]entity.HashPair, len(h.Pairs()))
+	for _, p := range h.Pairs() {
+		k := Eval(p.Key, env)
+		if interrupted(k) {
+			return k
+		}
+		hk, ok := k.(entity.Hashable)
+		if !ok {
+			return newErr(entity.KindIndex, "unusable as hash key: %s", k.Type())
+		}
+		v := Eval(p.Value, env)
+		if interrupted(v) {
+			return v
+		}
+		pairs[hk.HashKey()] = entity.HashPair{Key: k, Value: v}
 	}
+	return entity.Hash{Pairs: pairs}
 }
@@ -1,12 +1,16 @@
 package eval_test
 
 import (
+	"context"
 	"mmm/entity"
 	"mmm/eval"
 	"mmm/is"
 	"mmm/lexer"
+	"mmm/module"
 	"mmm/parser"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestEval(t *testing.T) {
@@ -134,6 +138,7 @@ func TestEval(t *testing.T) {
 			"Statement after return":            {input: "return 10; 9;", want: 10},
 			"Statement before and after return": {input: "9;return 10;9;", want: 10},
 			"Statement as expression":           {input: "return 2 * 5", want: 10},
+			"In an expression":                  {input: "let f = fn() { let a = [1, if (true) { return 10; } else { 2 }]; 3 }; f()", want: 10},
 			"No immediate return": {input: `
 			if (10 > 1) {
 				if (10 > 1) {
@@ -184,6 +189,71 @@ func TestEval(t *testing.T) {
 			})
 		}
 	})
+	t.Run("Error Positions", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Infix": {input: "5 + true;", want: "1:1: type mismatch: Int + Bool"},
+			"Nested": {input: `let x = 1;
+if (x > 0) {
+	-true;
+}`,
+			want: "3:2: unknown operator: -Bool"},
+			"Identifier": {input: "let a = 1;\n a + b;", want: "2:6: identifier not found: b"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				ent := setup(tc.input)
+				is.Equal(t, tc.want, ent.(entity.Error).Error())
+			})
+		}
+	})
+	t.Run("Error Kinds", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  entity.ErrorKind
+		}{
+			"Type mismatch":    {input: `1 + "a"`, want: entity.KindType},
+			"Unknown ident":    {input: "nope", want: entity.KindUnknownIdent},
+			"Arity":            {input: "fn(a) { a }(1, 2)", want: entity.KindArity},
+			"Builtin arity":    {input: "first()", want: entity.KindArity},
+			"Index":            {input: "1[0]", want: entity.KindIndex},
+			"Division by zero": {input: "1 / 0", want: entity.KindDivByZero},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).(entity.Error).Kind)
+			})
+		}
+	})
+	t.Run("Tracebacks", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Top level": {input: "-true", want: "1:1: unknown operator: -Bool"},
+			"Nested calls": {input: `let add = fn(a, b) { a + b };
+let outer = fn() { add(1, true) };
+outer();`, want: `1:22: type mismatch: Int + Bool
+	in add, called at 2:20
+	in outer, called at 3:1`},
+			"Anonymous": {input: "fn() { 1 / 0 }()", want: "1:8: division by zero\n\tin fn, called at 1:1"},
+			"Builtin":   {input: "let f = fn() { len(1) };\nf()", want: "1:16: argument to `len` not supported, got Int\n\tin f, called at 2:1"},
+			"Tail calls": {input: "let f = fn(n) { if (n == 0) { n + true } else { f(n - 1) } };\nf(3)", want: "1:31: type mismatch: Int + Bool\n\tin f, called at 1:49\n\tin f, called at 2:1"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).(entity.Error).Traceback())
+			})
+		}
+	})
 	t.Run("Let statements", func(t *testing.T) {
 		t.Parallel()
 		for name, tc := range map[string]struct {
@@ -263,6 +333,25 @@ addTwo(2);`,
 			})
 		}
 	})
+	t.Run("No value", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Empty body":       {input: "let f = fn() {}; str(f())", want: "null"},
+			"Ends with let":    {input: "let f = fn() { let a = 1; }; type(f())", want: "Null"},
+			"Ends with assign": {input: "let x = 0; let f = fn() { x = 1; }; [f(), x]", want: "[null, 1]"},
+			"If ends with let": {input: "str(if (true) { let a = 1; })", want: "null"},
+			"If without else":  {input: "[if (false) { 1 }]", want: "[null]"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
 	t.Run("String", func(t *testing.T) {
 		t.Parallel()
 		for name, tc := range map[string]struct {
@@ -317,9 +406,9 @@ addTwo(2);`,
 			want  string
 		}{
 			"Len: Bad type": { input:`len(1)`,
-			want: "ERROR: argument to `len` not supported, got Int"},
+			want: "ERROR: 1:1: argument to `len` not supported, got Int"},
 			"Len: Two params": { input:`len("Hey", " Yung Wurld!")`,
-			want: "ERROR: len only accepts one argument."},
+			want: "ERROR: 1:1: len only accepts one argument."},
 		} {
 			tc := tc
 			t.Run(name, func(t *testing.T) {
@@ -329,6 +418,28 @@ addTwo(2);`,
 			})
 		}
 	})
+	t.Run("Builtins", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Stack": {input: `
+let stack = push(push([], 1), 2);
+[first(stack), last(stack), rest(stack), len(stack)]`, want: "[1, 2, [2], 2]"},
+			"Strings": {input: `join(split("a,b,c", ","), "-") + str(int("4") + 1)`, want: "a-b-c5"},
+			"Hashes": {input: `
+let h = {"b": 2, "a": 1};
+[keys(h), values(h), contains(h, "a"), type(h)]`, want: "[[a, b], [1, 2], true, Hash]"},
+			"Concat": {input: "concat([1], [2, 3])", want: "[1, 2, 3]"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
 	t.Run("Slices", func(t *testing.T) {
 		t.Parallel()
 		for name, tc := range map[string]struct {
@@ -374,8 +485,508 @@ addTwo(2);`,
 			})
 		}
 	})
+	t.Run("Unicode", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Identifier":   {input: "let π = 3; let größe = π * 2; größe", want: "6"},
+			"Len":          {input: `len("héllo")`, want: "5"},
+			"Index":        {input: `"日本語"[1]`, want: "本"},
+			"Index range":  {input: `"日本語"[3]`, want: "null"},
+			"Iterate":      {input: `let n = 0; for (c in "héllo") { n += 1; } n`, want: "5"},
+			"Escape":       {input: `"\u{1F600}"`, want: "😀"},
+			"Bytes":        {input: `bytes("hé")`, want: "[104, 195, 169]"},
+			"Bytes length": {input: `len(bytes("héllo"))`, want: "6"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Floats", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Literal":         {input: "1.5", want: "1.5"},
+			"Exponent":        {input: "2e3", want: "2000.0"},
+			"Negative exp":    {input: "25e-1", want: "2.5"},
+			"Large":           {input: "1e21", want: "1e+21"},
+			"Negative":        {input: "-0.25", want: "-0.25"},
+			"Arithmetic":      {input: "1.5 + 2.25 * 2.0", want: "6.0"},
+			"Int promoted":    {input: "1 + 0.5", want: "1.5"},
+			"Float promoted":  {input: "0.5 * 4", want: "2.0"},
+			"Int division":    {input: "7 / 2", want: "3"},
+			"Float division":  {input: "7 / 2.0", want: "3.5"},
+			"Less":            {input: "1 < 1.5", want: "true"},
+			"Greater":         {input: "2.5 > 3", want: "false"},
+			"Equal":           {input: "1 == 1.0", want: "true"},
+			"Not equal":       {input: "0.1 + 0.2 != 0.3", want: "true"},
+			"Compound":        {input: "let x = 1; x += 0.5; x", want: "1.5"},
+			"Division by zero": {input: "1.0 / 0", want: "ERROR: 1:1: division by zero"},
+			"Mismatch":        {input: `1.5 + "a"`, want: "ERROR: 1:1: type mismatch: Float + String"},
+			"Hash key":        {input: "{1.5: 1, 1: 2}[1.5]", want: "1"},
+			"Conversions":     {input: `[int(2.9), float(2), float("0.5"), str(3.0)]`, want: "[2, 2.0, 0.5, 3.0]"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Big Integers", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Literal":          {input: "123456789012345678901234567890", want: "123456789012345678901234567890"},
+			"Add overflow":     {input: "9223372036854775807 + 1", want: "9223372036854775808"},
+			"Sub overflow":     {input: "-9223372036854775807 - 2", want: "-9223372036854775809"},
+			"Mul overflow":     {input: "4294967296 * 4294967296", want: "18446744073709551616"},
+			"Negate min":       {input: "-(-9223372036854775807 - 1)", want: "9223372036854775808"},
+			"Divide min":       {input: "(-9223372036854775807 - 1) / -1", want: "9223372036854775808"},
+			"Demoted":          {input: "type(9223372036854775807 + 1 - 1)", want: "Int"},
+			"Type":             {input: "type(99999999999999999999)", want: "BigInt"},
+			"Compare":          {input: "99999999999999999999 > 1", want: "true"},
+			"Equal":            {input: "99999999999999999999 == 99999999999999999998 + 1", want: "true"},
+			"Float promoted":   {input: "99999999999999999999 + 0.5", want: "1e+20"},
+			"Hash key":         {input: "{99999999999999999999: 1}[99999999999999999998 + 1]", want: "1"},
+			"Conversions":      {input: `[int("99999999999999999999"), float(99999999999999999999)]`, want: "[99999999999999999999, 1e+20]"},
+			"Factorial":        {input: "let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)", want: "15511210043330985984000000"},
+			"Division by zero": {input: "99999999999999999999 / 0", want: "ERROR: 1:1: division by zero"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Operators", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Less equal":          {input: "[1 <= 2, 2 <= 2, 3 <= 2]", want: "[true, true, false]"},
+			"Greater equal":       {input: "[1 >= 2, 2 >= 2, 3 >= 2]", want: "[false, true, true]"},
+			"Float compare":       {input: "[1.5 <= 1, 2 >= 1.5]", want: "[false, true]"},
+			"Modulo":              {input: "[7 % 3, -7 % 3, 7.5 % 2]", want: "[1, -1, 1.5]"},
+			"Modulo by zero":      {input: "1 % 0", want: "ERROR: 1:1: division by zero"},
+			"Bitwise":             {input: "[6 & 3, 6 | 3, 6 ^ 3]", want: "[2, 7, 5]"},
+			"Shifts":              {input: "[1 << 4, -16 >> 2, 1 >> 100]", want: "[16, -4, 0]"},
+			"Shift overflow":      {input: "1 << 64", want: "18446744073709551616"},
+			"Big bitwise":         {input: "(1 << 70 | 1) & 3", want: "1"},
+			"Negative shift":      {input: "1 << -1", want: "ERROR: 1:1: negative shift count: -1"},
+			"Bitwise Float":       {input: "1.5 & 1", want: "ERROR: 1:1: unknown operator: Float & Float"},
+			"Bitwise above equal": {input: "5 & 1 == 1", want: "true"},
+			"And":                 {input: "[true && true, true && false, false && true]", want: "[true, false, false]"},
+			"Or":                  {input: "[false || true, true || false, false || false]", want: "[true, true, false]"},
+			"Truthiness":          {input: "[1 && \"a\", false || 0]", want: "[true, true]"},
+			"Hash equal":          {input: `{"a": 1} == {"a": 1}`, want: "ERROR: 1:1: unknown operator: Hash == Hash"},
+			"Slice not equal":     {input: "[1] != [1]", want: "ERROR: 1:1: unknown operator: Slice != Slice"},
+			"Function equal":      {input: "let f = fn() {}; f == f", want: "ERROR: 1:18: unknown operator: Fn == Fn"},
+			"Null equal":          {input: "[fn() {}() == fn() {}(), true != false]", want: "[true, true]"},
+			"And short-circuits":  {input: "let x = 0; false && fn() { x = 1; true }(); x", want: "0"},
+			"Or short-circuits":   {input: "let x = 0; true || fn() { x = 1; true }(); x", want: "0"},
+			"Right side runs":     {input: "let x = 0; true && fn() { x = 1; false }(); x", want: "1"},
+			"Error on the right":  {input: "false || -true", want: "ERROR: 1:10: unknown operator: -Bool"},
+			"Precedence":          {input: "1 < 2 && 2 < 3 || 1 / 0", want: "true"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Assignments", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Reassign":        {input: "let x = 1; x = x + 1; x", want: "2"},
+			"Compound":        {input: "let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", want: "6"},
+			"Outer counter":   {input: "let n = 0; let inc = fn() { n += 1; }; inc(); inc(); n", want: "2"},
+			"Closure counter": {input: "let c = fn() { let n = 0; fn() { n += 1; n } }(); c(); c()", want: "2"},
+			"Shared local":    {input: "let f = fn() { let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n }; f()", want: "2"},
+			"Nested closures": {input: "let f = fn() { let n = 0; let g = fn() { fn() { n = 7 } }; g()(); n }; f()", want: "7"},
+			"Fresh locals":    {input: "let f = fn() { let n = 1; let g = fn() { n }; n = n + 1; g() }; f() + f()", want: "4"},
+			"Loop variable":   {input: "let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }); } fs[0]()", want: "2"},
+			"Slice index":     {input: "let xs = [1, 2, 3]; xs[1] = 20; xs[2] *= 3; xs", want: "[1, 20, 9]"},
+			"Shared slice":    {input: "let a = [1]; let b = a; b[0] = 2; a", want: "[2]"},
+			"Hash key":        {input: `let m = {"a": 1}; m["b"] = 2; m["a"] += 10; m`, want: "{a: 11, b: 2}"},
+			"Nested index":    {input: `let m = {"xs": [1]}; m["xs"][0] = 5; m`, want: "{xs: [5]}"},
+			"Assign itself":   {input: "let f = fn() { f = 1; 2 }; f() + f", want: "3"},
+			"Local itself":    {input: "let g = fn() { let f = fn(n) { if (n == 0) { f = 5; return 0; } f(n - 1) }; f(2) + f }; g()", want: "5"},
+			"Nested itself":   {input: "let f = fn() { fn() { f = 4 }(); 0 }; f() + f", want: "4"},
+			"Itself in loop":  {input: "let f = fn(n) { let r = 0; while (n > 0) { r = f; f = 3; n -= 1; } r }; f(2)", want: "3"},
+			"Undeclared":      {input: "x = 1;", want: "ERROR: 1:1: assignment to undeclared identifier: x"},
+			"Out of range":    {input: "let xs = [1]; xs[1] = 2;", want: "ERROR: 1:15: index 1 out of range for Slice of length 1"},
+			"Not indexable":   {input: "let x = 1; x[0] = 2;", want: "ERROR: 1:12: index assignment not supported for Int"},
+			"Compound error":  {input: `let x = 1; x += "a";`, want: "ERROR: 1:12: type mismatch: Int + String"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Defined later", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Called later":     {input: "let f = fn(x) { g(x) }; let g = fn(x) { x }; f(1)", want: "1"},
+			"Mutual recursion": {input: "let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10)", want: "true"},
+			"In a function":    {input: "let f = fn() { let h = fn() { g }; h() }; let g = 2; f()", want: "2"},
+			"Not yet defined":  {input: "let f = fn() { g }; let x = f(); let g = 1;", want: "ERROR: 1:16: identifier not found: g"},
+			"Never used":       {input: "if (false) { nope }; 1", want: "1"},
+			"Never defined":    {input: "nope", want: "ERROR: 1:1: identifier not found: nope"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Loops", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"While":         {input: "let i = 0; while (i < 5) { let i = i + 1; }; i", want: "5"},
+			"While false":   {input: "let i = 0; while (false) { let i = 1; }; i", want: "0"},
+			"Many times":    {input: "let i = 0; while (i < 100000) { let i = i + 1; }; i", want: "100000"},
+			"For Slice":     {input: "let s = 0; for (x in [1, 2, 3]) { let s = s + x; }; s", want: "6"},
+			"For String":    {input: `let s = ""; for (c in "abc") { let s = c + s; }; s`, want: "cba"},
+			"For Hash":      {input: `let s = ""; for (k in {"b": 1, "a": 2}) { let s = s + k; }; s`, want: "ab"},
+			"For Int":       {input: "for (x in 1) { x }", want: "ERROR: 1:1: cannot iterate over Int"},
+			"Break":         {input: "let i = 0; while (true) { let i = i + 1; if (i > 2) { break; } }; i", want: "3"},
+			"Continue":      {input: "let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let s = s + x; }; s", want: "8"},
+			"Break label":   {input: "let n = 0; outer: for (x in [1, 2]) { for (y in [1, 2]) { if (y == 2) { break outer; } let n = n + 1; } }; n", want: "1"},
+			"Continue label": {input: "let n = 0; outer: for (x in [1, 2]) { for (y in [1, 2]) { if (y == 2) { continue outer; } let n = n + 1; } }; n", want: "2"},
+			"Return from loop": {input: "let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", want: "20"},
+			"Error in loop": {input: "for (x in [1]) { x + true }", want: "ERROR: 1:18: type mismatch: Int + Bool"},
+			"Continue in expression": {input: "let s = []; for (x in [1, 2]) { let a = [x, if (x == 1) { continue; } else { 5 }]; s = push(s, a); }; s", want: "[[2, 5]]"},
+			"Break in expression": {input: "let n = 0; while (true) { n = n + if (n > 2) { break; } else { 1 }; }; n", want: "3"},
+			"Continue label in expression": {input: "let n = 0; outer: for (x in [1, 2]) { for (y in [1, 2]) { n = n + [y, if (y == 2) { continue outer; } else { 1 }][1]; } }; n", want: "2"},
+			"Continue in call": {input: "let i = 0; while (i < 5000) { i += 1; len([i], if (true) { continue; }); }; i", want: "5000"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Tail Calls", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Return":           {input: "let f = fn(n) { if (n == 0) { return 0; } return f(n - 1); }; f(300000)", want: "0"},
+			"Final expression": {input: "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(300000)", want: "0"},
+			"Accumulator":      {input: "let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)", want: "5000050000"},
+			"Mutual":           {input: "let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(100001)", want: "false"},
+			"Top level return": {input: "let f = fn(n) { n * 2 }; return f(21);", want: "42"},
+			"Not in tail":      {input: "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", want: "100"},
+			"Builtin":          {input: "let f = fn(s) { len(s) }; f(\"abc\")", want: "3"},
+			"Arity":            {input: "let f = fn(n) { f(n, 1) }; f(1)", want: "ERROR: 1:17: wrong number of arguments: want=1, got=2"},
+			"Error":            {input: "let f = fn(n) { if (n == 0) { n + true } else { f(n - 1) } }; f(100000)", want: "ERROR: 1:31: type mismatch: Int + Bool"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Hashes", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Empty":       {input: "{}", want: "{}"},
+			"Literal":     {input: `{"name": "x", 1: true}`, want: "{1: true, name: x}"},
+			"Expressions": {input: `let k = "b"; {"a" + k: 1 * 2, true: [3]}`, want: "{true: [3], ab: 2}"},
+			"Duplicate keys": {input: `{1: 1, 1: 2}`, want: "{1: 2}"},
+			"Index":       {input: `{"name": "x", 1: true}["name"]`, want: "x"},
+			"Index Int":   {input: `let h = {1: 10, 2: 20}; h[1 + 1]`, want: "20"},
+			"Index Bool":  {input: `{true: "yes", false: "no"}[1 > 2]`, want: "no"},
+			"Missing":     {input: `{"a": 1}["b"]`, want: "null"},
+			"Float index": {input: `{1: "a"}[1.0]`, want: "a"},
+			"Fraction index": {input: `{1: "a"}[1.5]`, want: "null"},
+			"Float contains": {input: `contains({1: "a"}, 1.0)`, want: "true"},
+			"Float key":   {input: `{2.0: "a"}[2]`, want: "a"},
+			"Unusable key": {input: `{fn(x) { x }: 1}`, want: "ERROR: 1:1: unusable as hash key: Fn"},
+			"Unusable index": {input: `{"a": 1}[[1]]`, want: "ERROR: 1:1: unusable as hash key: Slice"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Quote", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Integer":         {input: "quote(5)", want: "QUOTE(5)"},
+			"Infix":           {input: "quote(5 + 8)", want: "QUOTE((5 + 8))"},
+			"Ident":           {input: "quote(foobar)", want: "QUOTE(foobar)"},
+			"Unquote":         {input: "quote(unquote(4))", want: "QUOTE(4)"},
+			"Unquote infix":   {input: "quote(8 + unquote(4 + 4))", want: "QUOTE((8 + 8))"},
+			"Unquote binding": {input: "let foo = 8; quote(unquote(foo) + 1)", want: "QUOTE((8 + 1))"},
+			"Unquote Bool":    {input: "quote(unquote(true == false))", want: "QUOTE(false)"},
+			"Unquote Float":   {input: "quote(unquote(1.5 * 2.0))", want: "QUOTE(3.0)"},
+			"Unquote String":  {input: `quote(unquote("a" + "b"))`, want: "QUOTE(ab)"},
+			"Unquote Quote":   {input: "let q = quote(4 + 4); quote(unquote(q) * 2)", want: "QUOTE(((4 + 4) * 2))"},
+			"Evaluated":       {input: "let f = fn(x) { x }; quote(unquote(f(2)) + x)", want: "QUOTE((2 + x))"},
+			"No arguments":    {input: "quote()", want: "ERROR: 1:1: wrong number of arguments: want=1, got=0"},
+			"Unquote Slice":   {input: "quote(unquote([1]))", want: "ERROR: 1:7: cannot unquote Slice"},
+			"Unquote error":   {input: "quote(unquote(1 + true))", want: "ERROR: 1:15: type mismatch: Int + Bool"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				is.Equal(t, tc.want, setup(tc.input).Inspect())
+			})
+		}
+	})
+	t.Run("Macros", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Expanded": {
+				input: "let infix = macro() { quote(1 + 2) }; infix()",
+				want:  "3",
+			},
+			"Arguments unevaluated": {
+				input: "let rev = macro(a, b) { quote(unquote(b) - unquote(a)) }; rev(2 + 2, 10 - 5)",
+				want:  "1",
+			},
+			"Unless": {
+				input: `let unless = macro(c, t, f) { quote(if (!(unquote(c))) { unquote(t) } else { unquote(f) }) };
+					unless(10 > 5, "not greater", "greater")`,
+				want: "greater",
+			},
+			"Not a Quote": {
+				input: "let m = macro() { 1 }; m()",
+				want:  "ERROR: 1:24: macro must return a Quote, got Int",
+			},
+			"Arity": {
+				input: "let m = macro(a) { quote(a) }; m()",
+				want:  "ERROR: 1:32: wrong number of arguments: want=1, got=0",
+			},
+			"Not top level": {
+				input: "let f = fn() { let m = macro() { quote(1) }; m() }; f()",
+				want:  "ERROR: 1:46: not a function: Macro",
+			},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				env := entity.NewEnv()
+				prg := eval.DefineMacros(parser.New(lexer.New(tc.input)).Parse(), env)
+				prg, err := eval.ExpandMacros(prg, env)
+				if err != nil {
+					is.Equal(t, tc.want, "ERROR: "+err.Error())
+					return
+				}
+				is.Equal(t, tc.want, eval.Eval(prg, entity.NewEnv()).Inspect())
+			})
+		}
+	})
+	t.Run("Imports", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Selector":      {input: `let m = import "lib/math.mmm"; m.double(4)`, want: "8"},
+			"Index":         {input: `let m = import "lib/math.mmm"; m["double"](1)`, want: "2"},
+			"Module":        {input: `import "lib/math.mmm"`, want: `module "lib/math.mmm"`},
+			"Evaluated once": {input: `let a = import "lib/math.mmm"; a.count(); let b = import "/lib/math.mmm"; b.count()`, want: "2"},
+			"In a function": {input: `let f = fn() { import "lib/math.mmm" }; f().double(2)`, want: "4"},
+			"First run later": {input: `let f = fn() { import "lib/math.mmm" }; let m = import "lib/math.mmm"; m.count(); f().count()`, want: "2"},
+			"Early return":  {input: `(import "early.mmm").a`, want: "1"},
+			"After return":  {input: `(import "early.mmm").b`, want: `ERROR: 1:2: module "early.mmm" has no export b`},
+			"Private":       {input: `(import "lib/math.mmm")._n`, want: `ERROR: 1:2: module "lib/math.mmm" has no export _n`},
+			"Not a String":  {input: `(import "lib/math.mmm")[1]`, want: "ERROR: 1:2: module member must be a String, got Int"},
+			"Cycle":         {input: `import "a.mmm"`, want: "ERROR: b.mmm:1:1: import cycle: a.mmm -> b.mmm -> a.mmm"},
+			"Missing":       {input: `import "nope.mmm"`, want: `ERROR: 1:1: cannot import "nope.mmm": open nope.mmm: file does not exist`},
+			"Parse error":   {input: `import "bad.mmm"`, want: "ERROR: 1:1: bad.mmm:1:5: expected next token to be Ident, got Assign"},
+			"Runtime error": {input: `import "fail.mmm"`, want: "ERROR: fail.mmm:1:16: type mismatch: Int + Bool"},
+			"Invalid path":  {input: `import "../x.mmm"`, want: `ERROR: 1:1: invalid import path "../x.mmm"`},
+			"Defined later": {input: `(import "later.mmm").x`, want: "3"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				env := entity.NewEnv().WithImporter(eval.NewImporter(module.FSLoader{FS: modules}, ""))
+				is.Equal(t, tc.want, eval.Eval(parser.New(lexer.New(tc.input)).Parse(), env).Inspect())
+			})
+		}
+	})
+	t.Run("Imports not enabled", func(t *testing.T) {
+		t.Parallel()
+		is.Equal(t, `ERROR: 1:1: cannot import "x.mmm": imports are not enabled`,
+			setup(`import "x.mmm"`).Inspect())
+	})
+	t.Run("Host", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Func":             {input: "double(21)", want: "42"},
+			"Const":            {input: "answer + 1", want: "43"},
+			"Shadowed":         {input: "let answer = 1; answer", want: "1"},
+			"Replaces builtin": {input: `len("abc")`, want: "host len"},
+			"In a function":    {input: "let f = fn() { double(answer) }; f()", want: "84"},
+			"In a module":      {input: `(import "host.mmm").x`, want: "84"},
+			"Argument error":   {input: `double("a")`, want: "ERROR: 1:1: argument 1 to `double` must be Int, got String"},
+			"Assign":           {input: "answer = 1;", want: "ERROR: 1:1: assignment to undeclared identifier: answer"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				env := entity.NewEnv().WithHost(host).
+					WithImporter(eval.NewImporter(module.FSLoader{FS: modules}, ""))
+				is.Equal(t, tc.want, eval.Eval(parser.New(lexer.New(tc.input)).Parse(), env).Inspect())
+			})
+		}
+	})
 }
 
+func TestEvalWith(t *testing.T) {
+	t.Parallel()
+	for name, tc := range map[string]struct {
+		input string
+		opts  eval.Options
+		want  string
+	}{
+		"No limits":    {input: "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", want: "100"},
+		"Under limits": {input: "[1, 2, 3]", opts: eval.Options{MaxSteps: 10, MaxDepth: 1, MaxMemory: 48}, want: "[1, 2, 3]"},
+		"Runaway":      {input: "let f = fn() { f() }; f()", opts: eval.Options{MaxSteps: 10000}, want: "step limit exceeded: 10000"},
+		"Loop":         {input: "while (true) {}", opts: eval.Options{MaxSteps: 100}, want: "step limit exceeded: 100"},
+		"Depth":        {input: "let f = fn(n) { 1 + f(n) }; f(0)", opts: eval.Options{MaxDepth: 100}, want: "call depth limit exceeded: 100"},
+		"Tail calls":   {input: "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000)", opts: eval.Options{MaxDepth: 1}, want: "0"},
+		"Strings":      {input: `let f = fn(s) { f(s + s) }; f("ab")`, opts: eval.Options{MaxMemory: 1024}, want: "memory limit exceeded: 1024 bytes"},
+		"Slices":       {input: "let s = []; while (true) { let s = push(s, 1); }", opts: eval.Options{MaxMemory: 4096}, want: "memory limit exceeded: 4096 bytes"},
+		"Add assign":   {input: `let s = "ab"; let i = 0; while (i < 30) { s += s; i += 1; }`, opts: eval.Options{MaxMemory: 1 << 20}, want: "memory limit exceeded: 1048576 bytes"},
+		"Index assign": {input: `let a = ["ab"]; while (true) { a[0] += a[0]; }`, opts: eval.Options{MaxMemory: 1 << 20}, want: "memory limit exceeded: 1048576 bytes"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			res := eval.EvalWith(parser.New(lexer.New(tc.input)).Parse(), entity.NewEnv(), tc.opts)
+			if err, ok := res.(entity.Error); ok {
+				is.Equal(t, entity.KindLimit, err.Kind)
+				is.Equal(t, tc.want, err.Message)
+				return
+			}
+			is.Equal(t, tc.want, res.Inspect())
+		})
+	}
+	t.Run("Functions defined without limits", func(t *testing.T) {
+		t.Parallel()
+		env := entity.NewEnv()
+		eval.Eval(parser.New(lexer.New("let f = fn() { f() };")).Parse(), env)
+		res := eval.EvalWith(parser.New(lexer.New("f()")).Parse(), env, eval.Options{MaxSteps: 1000})
+		is.Equal(t, "1:16: step limit exceeded: 1000\n\tin f, called at 1:16\n\tin f, called at 1:1", res.(entity.Error).Traceback())
+	})
+	t.Run("Imports", func(t *testing.T) {
+		t.Parallel()
+		env := entity.NewEnv().WithImporter(eval.NewImporter(module.FSLoader{FS: modules}, ""))
+		res := eval.EvalWith(parser.New(lexer.New(`import "lib/math.mmm"`)).Parse(), env, eval.Options{MaxSteps: 3})
+		is.Equal(t, "step limit exceeded: 3", res.(entity.Error).Message)
+	})
+}
+
+func TestEvalContext(t *testing.T) {
+	t.Parallel()
+	for name, tc := range map[string]struct {
+		// timeout is how long the program can run for, there's no timeout when
+		// it's zero.
+		timeout time.Duration
+		input   string
+		want    string
+	}{
+		"Done":       {input: "let i = 0; while (i < 10) { i += 1; }; i", want: "10"},
+		"While":      {timeout: 10 * time.Millisecond, input: "while (true) {}", want: "ERROR: 1:1: context deadline exceeded"},
+		"For":        {timeout: -1, input: "for (x in [1, 2]) {}", want: "ERROR: 1:1: context deadline exceeded"},
+		"Call":       {timeout: -1, input: "let f = fn() { 1 }; f()", want: "ERROR: 1:21: context deadline exceeded"},
+		"Tail calls": {timeout: 10 * time.Millisecond, input: "let f = fn() { f() }; f()", want: "ERROR: 1:16: context deadline exceeded"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			if tc.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			res := eval.EvalContext(ctx, parser.New(lexer.New(tc.input)).Parse(), entity.NewEnv(), eval.Options{})
+			if err, ok := res.(entity.Error); ok {
+				is.Equal(t, entity.KindCanceled, err.Kind)
+			}
+			is.Equal(t, tc.want, res.Inspect())
+		})
+	}
+}
+
+// modules are the modules the Imports tests can import.
+var modules = fstest.MapFS{
+	"lib/math.mmm": {Data: []byte(`let h = import "helper.mmm";
+let _n = 0;
+let count = fn() { _n += 1; _n };
+let double = fn(x) { h.twice(x) };`)},
+	"lib/helper.mmm": {Data: []byte("let twice = fn(x) { x * 2 };")},
+	"early.mmm":      {Data: []byte("let a = 1; if (a > 0) { return 0; } let b = 2;")},
+	"a.mmm":          {Data: []byte(`import "b.mmm"`)},
+	"b.mmm":          {Data: []byte(`import "a.mmm"`)},
+	"bad.mmm":        {Data: []byte("let = 1;")},
+	"fail.mmm":       {Data: []byte("let f = fn() { 1 + true };\nf();")},
+	"host.mmm":       {Data: []byte("let x = double(answer);")},
+	"later.mmm":      {Data: []byte("let f = fn() { g() }; let g = fn() { 3 }; let x = f();")},
+}
+
+// host is the Host the Host tests run with.
+var host = func() *entity.Host {
+	h := &entity.Host{}
+	h.Const("answer", entity.Int{Value: 42})
+	h.Func("double", func(args ...entity.E) entity.E {
+		if err := entity.CheckArgs("double", args, entity.TypeInt); err != nil {
+			return err
+		}
+		return entity.Int{Value: args[0].(entity.Int).Value * 2}
+	})
+	h.Func("len", func(args ...entity.E) entity.E { return entity.String{Value: "host len"} })
+	return h
+}()
+
 func setup(input string) entity.E {
 	return eval.Eval(parser.New(lexer.New(input)).Parse(), entity.NewEnv())
 }
@@ -0,0 +1,75 @@
+package eval
+
+import (
+	"mmm/entity"
+	"mmm/module"
+)
+
+// modules are the modules imported by a program, each one is evaluated the
+// first time it's imported and the same Module is shared by every later
+// import of it.
+type modules struct {
+	loader module.Loader
+	cache  map[string]entity.Module
+	// loading are the modules being evaluated, the outermost first.
+	loading []string
+}
+
+// importer imports modules for the module called from.
+type importer struct {
+	*modules
+	from string
+}
+
+// NewImporter returns the Importer of the program called main, which loads the
+// modules it imports from l. main is empty when the program isn't a module
+// itself, e.g. the input of the REPL.
+//
+//	env := entity.NewEnv().WithImporter(eval.NewImporter(l, "main.mmm"))
+func NewImporter(l module.Loader, main string) entity.Importer {
+	m := &modules{loader: l, cache: map[string]entity.Module{}}
+	if main != "" {
+		m.loading = []string{main}
+	}
+	return importer{modules: m, from: main}
+}
+
+// Import evaluates the module imported as path, with the Meter and Host of env
+// when it hasn't been imported yet.
+func (i importer) Import(path string, env entity.Env) entity.E {
+	name, err := module.Resolve(i.from, path)
+	if err != nil {
+		return entity.Errorf("%s", err)
+	}
+	if m, ok := i.cache[name]; ok {
+		return m
+	}
+	if err := module.Cycle(i.loading, name); err != nil {
+		return entity.Errorf("%s", err)
+	}
+	prg, err := module.Load(i.loader, name)
+	if err != nil {
+		return entity.Errorf("%s", err)
+	}
+	macros := entity.NewEnv()
+	if prg, err = ExpandMacros(DefineMacros(prg, macros), macros); err != nil {
+		return err.(entity.Error)
+	}
+	i.loading = append(i.loading, name)
+	env = entity.NewEnv().WithImporter(importer{modules: i.modules, from: name}).
+		WithMeter(env.Meter()).WithHost(env.Host())
+	res := Eval(prg, env)
+	i.loading = i.loading[:len(i.loading)-1]
+	if isErr(res) {
+		return res
+	}
+	exports := map[string]entity.E{}
+	for _, n := range env.Names() {
+		if module.Exported(n) {
+			exports[n], _ = env.Get(n)
+		}
+	}
+	m := entity.Module{Name: name, Exports: exports}
+	i.cache[name] = m
+	return m
+}
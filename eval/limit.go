@@ -0,0 +1,114 @@
+package eval
+
+import (
+	"context"
+
+	"mmm/ast"
+	"mmm/entity"
+)
+
+// Options are the limits a program is evaluated with, so that a program that
+// can't be trusted can't run forever or use up all the memory. A limit of zero
+// is no limit at all.
+type Options struct {
+	// MaxSteps is how many nodes can be evaluated.
+	MaxSteps int
+	// MaxDepth is how deeply calls of user defined functions can nest. Calls in
+	// tail position replace the call they're in, so they don't nest.
+	MaxDepth int
+	// MaxMemory is how many bytes the Slices and Strings made can add up to,
+	// roughly. Memory that's no longer used still counts towards it.
+	MaxMemory int
+}
+
+// EvalWith evaluates node in env like Eval, stopping with an [entity.Error] of
+// [entity.KindLimit] as soon as it goes over one of the limits of opts.
+//
+//	res := eval.EvalWith(prg, env, eval.Options{MaxSteps: 1_000_000, MaxDepth: 1000})
+func EvalWith(node ast.Node, env entity.Env, opts Options) entity.E {
+	return EvalContext(context.Background(), node, env, opts)
+}
+
+// EvalContext is like EvalWith, but also stops with an [entity.Error] of
+// [entity.KindCanceled] once ctx is done. ctx is checked every time a loop goes
+// round and a user defined function is called, tail calls included.
+//
+//	ctx, cancel := context.WithTimeout(ctx, time.Second)
+//	defer cancel()
+//	res := eval.EvalContext(ctx, prg, env, eval.Options{})
+func EvalContext(ctx context.Context, node ast.Node, env entity.Env, opts Options) entity.E {
+	return Eval(node, env.WithMeter(&limiter{opts: opts, ctx: ctx, done: ctx.Done()}))
+}
+
+// limiter is the Meter of a single EvalContext.
+type limiter struct {
+	opts              Options
+	steps, depth, mem int
+	ctx               context.Context
+	// done is ctx.Done(), which is nil when ctx can't be canceled.
+	done <-chan struct{}
+}
+
+// canceled returns the Error to stop with once ctx is done.
+func (l *limiter) canceled() entity.E {
+	select {
+	case <-l.done:
+		return newErr(entity.KindCanceled, "%s", l.ctx.Err())
+	default:
+		return nil
+	}
+}
+
+func (l *limiter) Step() entity.E {
+	if l.steps++; l.opts.MaxSteps > 0 && l.steps > l.opts.MaxSteps {
+		return newErr(entity.KindLimit, "step limit exceeded: %d", l.opts.MaxSteps)
+	}
+	return nil
+}
+
+func (l *limiter) Call() entity.E {
+	if err := l.canceled(); err != nil {
+		return err
+	}
+	if l.depth++; l.opts.MaxDepth > 0 && l.depth > l.opts.MaxDepth {
+		l.depth--
+		return newErr(entity.KindLimit, "call depth limit exceeded: %d", l.opts.MaxDepth)
+	}
+	return nil
+}
+
+func (l *limiter) Return() { l.depth-- }
+
+func (l *limiter) Loop() entity.E { return l.canceled() }
+
+func (l *limiter) Alloc(size int) entity.E {
+	if l.mem += size; l.opts.MaxMemory > 0 && l.mem > l.opts.MaxMemory {
+		return newErr(entity.KindLimit, "memory limit exceeded: %d bytes", l.opts.MaxMemory)
+	}
+	return nil
+}
+
+// sizeofE is the size of an [entity.E] in a Slice, what it refers to isn't
+// counted.
+const sizeofE = 16
+
+// alloc charges m for e when it's a Slice or a String, it returns the Error m
+// stops with instead of e.
+func alloc(m entity.Meter, e entity.E) entity.E {
+	if m == nil {
+		return e
+	}
+	var size int
+	switch e := e.(type) {
+	case entity.String:
+		size = len(e.Value)
+	case entity.Slice:
+		size = len(e.Values) * sizeofE
+	default:
+		return e
+	}
+	if err := m.Alloc(size); err != nil {
+		return err
+	}
+	return e
+}
@@ -0,0 +1,156 @@
+package eval
+
+import (
+	"mmm/ast"
+	"mmm/entity"
+	"mmm/token"
+)
+
+// DefineMacros binds every macro defined at the top level of prg, i.e.
+// `let name = macro(...) {...}`, in env and returns prg without them.
+func DefineMacros(prg ast.Program, env entity.Env) ast.Program {
+	stmts := make([]ast.Statement, 0, len(prg.Statements))
+	for _, s := range prg.Statements {
+		if let, ok := s.(ast.LetStmt); ok {
+			if m, ok := let.Value().(ast.MacroLiteral); ok {
+				env.Set(let.Name(), entity.Macro{Params: m.Params, Body: m.Body, Env: env})
+				continue
+			}
+		}
+		stmts = append(stmts, s)
+	}
+	prg.Statements = stmts
+	return prg
+}
+
+// ExpandMacros replaces every call of a macro bound in env with the AST of the
+// Quote the macro returns. The arguments are passed to the macro as Quotes of
+// their unevaluated ASTs. The AST a macro returns isn't expanded again. The
+// error is always an [entity.Error].
+func ExpandMacros(prg ast.Program, env entity.Env) (ast.Program, error) {
+	var err error
+	n := ast.Modify(prg, func(n ast.Node) ast.Node {
+		call, ok := n.(ast.CallExpr)
+		if !ok || err != nil {
+			return n
+		}
+		id, ok := call.Fn.(ast.Ident)
+		if !ok {
+			return n
+		}
+		v, _ := env.Get(id.String())
+		m, ok := v.(entity.Macro)
+		if !ok {
+			return n
+		}
+		res := expandMacro(m, call)
+		if e, ok := res.(entity.Error); ok {
+			e.Stack = append(e.Stack, entity.Frame{Fn: id.String(), Pos: call.Pos()})
+			err = e
+			return n
+		}
+		return res.(entity.Quote).Node
+	})
+	if err != nil {
+		return prg, err
+	}
+	return n.(ast.Program), nil
+}
+
+// expandMacro calls m with the arguments of call and returns the Quote it
+// produced, or an Error.
+func expandMacro(m entity.Macro, call ast.CallExpr) entity.E {
+	if len(call.Args) != len(m.Params) {
+		return errAt(newErr(entity.KindArity, "wrong number of arguments: want=%d, got=%d",
+			len(m.Params), len(call.Args)), call)
+	}
+	env := entity.NewEnvWith(&m.Env)
+	for i, p := range m.Params {
+		env.Set(p.String(), entity.Quote{Node: call.Args[i]})
+	}
+	res := Eval(m.Body, env)
+	if ret, ok := res.(entity.Return); ok {
+		res = ret.Value
+	}
+	if tc, ok := res.(tailCall); ok {
+		res = tc.call()
+	}
+	switch res := res.(type) {
+	case entity.Quote:
+		return res
+	case entity.Error:
+		if !res.Pos.IsValid() {
+			res = errAt(res, call)
+		}
+		return res
+	case nil:
+		return errAt(newErr(entity.KindType, "macro must return a Quote, got nothing"), call)
+	default:
+		return errAt(newErr(entity.KindType, "macro must return a Quote, got %s", res.Type()), call)
+	}
+}
+
+// quote returns the AST of node unevaluated, except for the calls to unquote in
+// it which are replaced with the AST of what their argument evaluates to.
+func quote(node ast.Node, env entity.Env) entity.E {
+	var err entity.E
+	node = ast.Modify(node, func(n ast.Node) ast.Node {
+		call, ok := n.(ast.CallExpr)
+		if !ok || err != nil {
+			return n
+		}
+		if id, ok := call.Fn.(ast.Ident); !ok || id.String() != "unquote" {
+			return n
+		}
+		if len(call.Args) != 1 {
+			err = newErr(entity.KindArity, "wrong number of arguments: want=1, got=%d",
+				len(call.Args))
+			return n
+		}
+		v := Eval(call.Args[0], env)
+		if isErr(v) {
+			err = v
+			return n
+		}
+		res, ok := toNode(v, call)
+		if !ok {
+			err = errAt(newErr(entity.KindType, "cannot unquote %s", v.Type()), call)
+			return n
+		}
+		return res
+	})
+	if err != nil {
+		return err
+	}
+	return entity.Quote{Node: node}
+}
+
+// toNode turns e back into an AST that evaluates to it, placed where at is. It
+// reports false for entities that have no literal form.
+func toNode(e entity.E, at ast.Node) (ast.Node, bool) {
+	tok := func(t token.Type, lit string) token.Token {
+		return token.New(t, lit).At(at.Pos(), at.End())
+	}
+	switch e := e.(type) {
+	case entity.Int:
+		return ast.NewInteger(tok(token.TypeInt, e.Inspect()), e.Value), true
+	case entity.BigInt:
+		return ast.NewBigInteger(tok(token.TypeInt, e.Inspect()), e.Value), true
+	case entity.Float:
+		return ast.NewFloat(tok(token.TypeFloat, e.Inspect()), e.Value), true
+	case entity.Bool:
+		return ast.NewBool(tok(token.TypeBool, e.Inspect()), e.Value), true
+	case entity.String:
+		return ast.NewString(tok(token.TypeString, e.Value)), true
+	case entity.Quote:
+		return e.Node, e.Node != nil
+	default:
+		return nil, false
+	}
+}
+
+// errAt positions err at n.
+func errAt(err entity.Error, n ast.Node) entity.Error {
+	err.Pos, err.End = n.Pos(), n.End()
+	return err
+}
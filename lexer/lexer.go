@@ -1,33 +1,89 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"mmm/token"
 )
 
 // Lexer takes an input and turns it into a slice of [token.Token]. This
 // implementation doesn't care about whitespace.
 type Lexer struct {
+	// file is the name of the file the input came from, if any.
+	file string
 	// input is the actual value we are trying to tokenize.
 	input string
 	// cPos is the current cPos in the input string.
 	cPos uint
 	// nPos is the next position in the input string.
 	nPos uint
-	// ch is the char at cPos.
-	ch byte
+	// ch is the char at cPos, decoded from UTF-8.
+	ch rune
+	// line and col are the 1-based line and column of ch.
+	line, col int
+	// errs explain every Illegal token the Lexer has produced so far.
+	errs []Error
+	mode Mode
+}
+
+// Mode changes which tokens a Lexer produces.
+type Mode uint8
+
+const (
+	// ScanComments makes the Lexer produce a Comment token for every comment
+	// instead of skipping over them, for tools that need to keep them.
+	ScanComments Mode = 1 << iota
+)
+
+// Error explains why the Lexer produced an Illegal token at Pos.
+type Error struct {
+	Pos token.Pos
+	Msg string
+}
+
+func (e Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
+	}
+	return e.Pos.String() + ": " + e.Msg
 }
 
 // New returns a Lexer that will parse the input token by token.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile is like New, but every [token.Pos] the Lexer produces will also
+// report the filename.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{file: filename, input: input, line: 1, col: 1}
 	l.readChar()
 	return l
 }
 
+// SetMode changes which tokens the Lexer produces from now on.
+func (l *Lexer) SetMode(m Mode) {
+	l.mode = m
+}
+
 // NextToken provides the next token in the Lexer's input.
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 	l.eatWhitespace()
+	start := l.pos()
+	if l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*') {
+		tok := l.readComment(start).At(start, l.pos())
+		if l.mode&ScanComments == 0 && tok.Type() == token.TypeComment {
+			return l.NextToken()
+		}
+		return tok
+	}
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -37,13 +93,13 @@ func (l *Lexer) NextToken() token.Token {
 		}
 		tok = token.New(token.TypeAssign, string(l.ch))
 	case '+':
-		tok = token.New(token.TypePlus, string(l.ch))
+		tok = l.twoChar('=', token.TypePlusAssign, token.TypePlus)
 	case '-':
-		tok = token.New(token.TypeMinus, string(l.ch))
+		tok = l.twoChar('=', token.TypeMinusAssign, token.TypeMinus)
 	case '*':
-		tok = token.New(token.TypeStar, string(l.ch))
+		tok = l.twoChar('=', token.TypeStarAssign, token.TypeStar)
 	case '/':
-		tok = token.New(token.TypeSlash, string(l.ch))
+		tok = l.twoChar('=', token.TypeSlashAssign, token.TypeSlash)
 	case '!':
 		if l.peekChar() == '=' {
 			tok = token.New(token.TypeNotEQ, "!=")
@@ -52,11 +108,29 @@ func (l *Lexer) NextToken() token.Token {
 		}
 		tok = token.New(token.TypeBang, string(l.ch))
 	case '<':
-		tok = token.New(token.TypeLT, string(l.ch))
+		tok = l.twoChar('<', token.TypeShl, token.TypeLT)
+		if tok.Type() == token.TypeLT {
+			tok = l.twoChar('=', token.TypeLTEQ, token.TypeLT)
+		}
 	case '>':
-		tok = token.New(token.TypeGT, string(l.ch))
+		tok = l.twoChar('>', token.TypeShr, token.TypeGT)
+		if tok.Type() == token.TypeGT {
+			tok = l.twoChar('=', token.TypeGTEQ, token.TypeGT)
+		}
+	case '&':
+		tok = l.twoChar('&', token.TypeAnd, token.TypeAmp)
+	case '|':
+		tok = l.twoChar('|', token.TypeOr, token.TypePipe)
+	case '%':
+		tok = token.New(token.TypePercent, string(l.ch))
+	case '^':
+		tok = token.New(token.TypeCaret, string(l.ch))
 	case ';':
 		tok = token.New(token.TypeSemicolon, string(l.ch))
+	case ':':
+		tok = token.New(token.TypeColon, string(l.ch))
+	case '.':
+		tok = token.New(token.TypeDot, string(l.ch))
 	case '(':
 		tok = token.New(token.TypeLParen, string(l.ch))
 	case ')':
@@ -72,34 +146,72 @@ func (l *Lexer) NextToken() token.Token {
 	case ']':
 		tok = token.New(token.TypeRBrakt, string(l.ch))
 	case 0:
-		tok = token.New(token.TypeEOF, "")
+		return token.New(token.TypeEOF, "").At(start, start)
 	case '"':
-		tok = token.New(token.TypeString, l.readString())
+		return l.readString(start).At(start, l.pos())
+	case '`':
+		return l.readRawString(start).At(start, l.pos())
 	default:
 		switch {
 		case isLetter(l.ch):
-			return token.New(token.TypeLookup, l.readIdentifier())
+			return token.New(token.TypeLookup, l.readIdentifier()).At(start, l.pos())
 		case isDigit(l.ch):
-			return token.New(token.TypeInt, l.readNumber())
+			return l.readNumber(start).At(start, l.pos())
+		case l.ch == utf8.RuneError && l.nPos-l.cPos == 1:
+			l.errorf(start, "invalid UTF-8 encoding")
+			tok = token.New(token.TypeIllegal, l.input[l.cPos:l.nPos])
 		default:
+			l.errorf(start, "unexpected character %q", l.ch)
 			tok = token.New(token.TypeIllegal, string(l.ch))
 		}
 	}
 	l.readChar()
-	return tok
+	return tok.At(start, l.pos())
+}
+
+// Errors explain the Illegal tokens the Lexer has produced so far, in the
+// order they were produced.
+func (l *Lexer) Errors() []Error {
+	return l.errs
+}
+
+func (l *Lexer) errorf(pos token.Pos, format string, a ...any) {
+	l.errs = append(l.errs, Error{Pos: pos, Msg: fmt.Sprintf(format, a...)})
+}
+
+// pos is the position of the char the Lexer is currently looking at.
+func (l Lexer) pos() token.Pos {
+	return token.Pos{File: l.file, Line: l.line, Col: l.col, Offset: int(l.cPos)}
 }
 
 func (l *Lexer) readChar() {
-	l.ch = 0
-	if l.nPos < uint(len(l.input)) {
-		l.ch = l.input[l.nPos]
+	if l.ch == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col += int(l.nPos - l.cPos)
 	}
 	l.cPos = l.nPos
-	l.nPos++
+	l.ch, l.nPos = 0, l.nPos+1
+	if l.cPos < uint(len(l.input)) {
+		r, w := utf8.DecodeRuneInString(l.input[l.cPos:])
+		l.ch, l.nPos = r, l.cPos+uint(w)
+	}
+}
+
+// twoChar is a Token of type two when the char after the current one is next,
+// which is consumed, otherwise it's a Token of type one for the current char.
+func (l *Lexer) twoChar(next byte, two, one token.Type) token.Token {
+	if l.peekChar() != next {
+		return token.New(one, string(l.ch))
+	}
+	lit := l.input[l.cPos : l.nPos+1]
+	l.readChar()
+	return token.New(two, lit)
 }
 
 func (l Lexer) peekChar() byte {
-	if l.nPos == uint(len(l.input)) {
+	if l.nPos >= uint(len(l.input)) {
 		return 0
 	}
 	return l.input[l.nPos]
@@ -113,12 +225,60 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.cPos]
 }
 
-func (l *Lexer) readNumber() string {
-	start := l.cPos
-	for isDigit(l.ch) {
+// readNumber reads an Int, or a Float when the digits are followed by a
+// fraction like 1.5 or an exponent like 1e-3, or both. An Int may have a 0x,
+// 0o or 0b prefix and any number may have _ between its digits. A number that
+// doesn't follow these rules is an Illegal token.
+func (l *Lexer) readNumber(start token.Pos) token.Token {
+	typ := token.TypeInt
+	if l.ch == '0' && strings.IndexByte("xXoObB", l.peekChar()) >= 0 {
+		l.readChar()
+		l.readChar()
+	} else {
+		l.readDigits()
+		if l.ch == '.' && isDigit(rune(l.peekChar())) {
+			typ = token.TypeFloat
+			l.readChar()
+			l.readDigits()
+		}
+		if l.ch == 'e' || l.ch == 'E' {
+			next := l.peekChar()
+			if (next == '+' || next == '-') && int(l.nPos)+1 < len(l.input) {
+				next = l.input[l.nPos+1]
+				if isDigit(rune(next)) {
+					l.readChar()
+				}
+			}
+			if isDigit(rune(next)) {
+				typ = token.TypeFloat
+				l.readChar()
+				l.readDigits()
+			}
+		}
+	}
+	// Letters and digits straight after a number are part of it, so that 0xZZ
+	// is a bad number rather than 0 followed by the identifier xZZ.
+	for isLetter(l.ch) || isDigit(l.ch) {
+		l.readChar()
+	}
+	lit := l.input[start.Offset:l.cPos]
+	if typ == token.TypeInt {
+		if _, ok := new(big.Int).SetString(lit, 0); !ok {
+			l.errorf(start, "invalid integer literal %q", lit)
+			return token.New(token.TypeIllegal, lit)
+		}
+	} else if _, err := strconv.ParseFloat(lit, 64); err != nil && !errors.Is(err, strconv.ErrRange) {
+		l.errorf(start, "invalid float literal %q", lit)
+		return token.New(token.TypeIllegal, lit)
+	}
+	return token.New(typ, lit)
+}
+
+// readDigits reads decimal digits and the _ that may separate them.
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
-	return l.input[start:l.cPos]
 }
 
 func (l *Lexer) eatWhitespace() {
@@ -127,17 +287,132 @@ func (l *Lexer) eatWhitespace() {
 	}
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isLetter reports whether ch can be part of an identifier, which is any
+// Unicode letter or _.
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(b byte) bool { return '0' <= b && b <= '9' }
+func isDigit(r rune) bool { return '0' <= r && r <= '9' }
 
-func (l *Lexer) readString() string {
+// readString reads a string in double quotes, decoding its escape sequences.
+// A string that isn't closed or has a malformed escape is an Illegal token of
+// everything that was read.
+func (l *Lexer) readString(start token.Pos) token.Token {
+	var b strings.Builder
+	var bad bool
 	l.readChar()
-	start := l.cPos
-	for l.ch != '"' && l.ch != 0 {
+	for l.ch != '"' {
+		switch l.ch {
+		case 0:
+			l.errorf(start, "unterminated string")
+			return token.New(token.TypeIllegal, l.input[start.Offset:l.cPos])
+		case '\\':
+			esc := l.pos()
+			r, err := l.readEscape()
+			if err != nil && !bad {
+				l.errorf(esc, "%s", err)
+			}
+			bad = bad || err != nil
+			b.WriteRune(r)
+		default:
+			b.WriteString(l.input[l.cPos:l.nPos])
+			l.readChar()
+		}
+	}
+	l.readChar()
+	if bad {
+		return token.New(token.TypeIllegal, l.input[start.Offset:l.cPos])
+	}
+	return token.New(token.TypeString, b.String())
+}
+
+// escapes are the single character escape sequences, \u{...} is handled on its
+// own.
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+}
+
+// readEscape reads the escape sequence starting at the backslash l is on and
+// leaves l on the char after it.
+func (l *Lexer) readEscape() (rune, error) {
+	l.readChar()
+	c := l.ch
+	if r, ok := escapes[c]; ok {
+		l.readChar()
+		return r, nil
+	}
+	if c == 0 {
+		// readString reports the unterminated string.
+		return 0, nil
+	}
+	if c != 'u' {
+		l.readChar()
+		return 0, fmt.Errorf("unknown escape sequence \\%c", c)
+	}
+	l.readChar()
+	if l.ch != '{' {
+		return 0, fmt.Errorf("malformed escape sequence, want \\u{hex}")
+	}
+	l.readChar()
+	hexStart := l.cPos
+	for l.ch != '}' && l.ch != '"' && l.ch != 0 {
+		l.readChar()
+	}
+	hex := l.input[hexStart:l.cPos]
+	if l.ch != '}' {
+		return 0, fmt.Errorf("malformed escape sequence, want \\u{hex}")
+	}
+	l.readChar()
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) > 6 || !utf8.ValidRune(rune(v)) {
+		return 0, fmt.Errorf("invalid Unicode code point \\u{%s}", hex)
+	}
+	return rune(v), nil
+}
+
+// readComment reads a // comment up to the end of its line or a /* */ comment
+// up to its closing */.
+func (l *Lexer) readComment(start token.Pos) token.Token {
+	l.readChar()
+	if l.ch == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return token.New(token.TypeComment, l.input[start.Offset:l.cPos])
+	}
+	l.readChar()
+	for l.ch != '*' || l.peekChar() != '/' {
+		if l.ch == 0 {
+			l.errorf(start, "unterminated comment")
+			return token.New(token.TypeIllegal, l.input[start.Offset:l.cPos])
+		}
+		l.readChar()
+	}
+	l.readChar()
+	l.readChar()
+	return token.New(token.TypeComment, l.input[start.Offset:l.cPos])
+}
+
+// readRawString reads a string in backticks, which may span lines and has no
+// escape sequences.
+func (l *Lexer) readRawString(start token.Pos) token.Token {
+	l.readChar()
+	from := l.cPos
+	for l.ch != '`' {
+		if l.ch == 0 {
+			l.errorf(start, "unterminated raw string")
+			return token.New(token.TypeIllegal, l.input[start.Offset:l.cPos])
+		}
 		l.readChar()
 	}
-	return l.input[start:l.cPos]
+	lit := l.input[from:l.cPos]
+	l.readChar()
+	return token.New(token.TypeString, lit)
 }
@@ -4,6 +4,7 @@ import (
 	"mmm/is"
 	"mmm/lexer"
 	"mmm/token"
+	"strings"
 	"testing"
 )
 
@@ -64,7 +65,7 @@ let result = add(five, ten);`,
 			},
 		},
 		"Invalid parsed code gives valid tokens": {
-			input: `!-/*5;`,
+			input: `!-/ *5;`,
 			toks: []token.Token{
 				token.New(token.TypeBang, "!"),
 				token.New(token.TypeMinus, "-"),
@@ -136,6 +137,66 @@ if (5 < 10) {
 				token.New(token.TypeString, "foo bar"),
 			},
 		},
+		"Escapes": {
+			input: `"a\tb\n" "\"q\" \\ \0" "\u{48}\u{1F600}"`,
+			toks: []token.Token{
+				token.New(token.TypeString, "a\tb\n"),
+				token.New(token.TypeString, "\"q\" \\ \x00"),
+				token.New(token.TypeString, "H\U0001F600"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Raw strings": {
+			input: "`a\\n\nb\"` 1",
+			toks: []token.Token{
+				token.New(token.TypeString, "a\\n\nb\""),
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Malformed strings": {
+			input: `"a\qb" "\u{110000}" "\u41" 1 "open`,
+			toks: []token.Token{
+				token.New(token.TypeIllegal, `"a\qb"`),
+				token.New(token.TypeIllegal, `"\u{110000}"`),
+				token.New(token.TypeIllegal, `"\u41"`),
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeIllegal, `"open`),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Comments": {
+			input: "1 // one { \n/ 2 /* two\n */ /= 3 /**/",
+			toks: []token.Token{
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeSlash, "/"),
+				token.New(token.TypeInt, "2"),
+				token.New(token.TypeSlashAssign, "/="),
+				token.New(token.TypeInt, "3"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Unterminated comment": {
+			input: "1 /* one",
+			toks: []token.Token{
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeIllegal, "/* one"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Unicode": {
+			input: `let héllo = "wörld"; 日本 ∑`,
+			toks: []token.Token{
+				token.New(token.TypeLet, "let"),
+				token.New(token.TypeIdent, "héllo"),
+				token.New(token.TypeAssign, "="),
+				token.New(token.TypeString, "wörld"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeIdent, "日本"),
+				token.New(token.TypeIllegal, "∑"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
 		"Slices": {
 			input: "[]; [1]; [1,2];",
 			toks: []token.Token{
@@ -155,6 +216,170 @@ if (5 < 10) {
 				token.New(token.TypeEOF, ""),
 			},
 		},
+		"Hashes": {
+			input: `{"foo": 1}`,
+			toks: []token.Token{
+				token.New(token.TypeLBrace, "{"),
+				token.New(token.TypeString, "foo"),
+				token.New(token.TypeColon, ":"),
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeRBrace, "}"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Assignments": {
+			input: `x += 1; x -= 2; x *= 3; x /= 4; x = 5 / 6;`,
+			toks: []token.Token{
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypePlusAssign, "+="),
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeMinusAssign, "-="),
+				token.New(token.TypeInt, "2"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeStarAssign, "*="),
+				token.New(token.TypeInt, "3"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeSlashAssign, "/="),
+				token.New(token.TypeInt, "4"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeAssign, "="),
+				token.New(token.TypeInt, "5"),
+				token.New(token.TypeSlash, "/"),
+				token.New(token.TypeInt, "6"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Operators": {
+			input: `a <= b >= c && d || e % f & g | h ^ i << j >> k < l > m`,
+			toks: []token.Token{
+				token.New(token.TypeIdent, "a"),
+				token.New(token.TypeLTEQ, "<="),
+				token.New(token.TypeIdent, "b"),
+				token.New(token.TypeGTEQ, ">="),
+				token.New(token.TypeIdent, "c"),
+				token.New(token.TypeAnd, "&&"),
+				token.New(token.TypeIdent, "d"),
+				token.New(token.TypeOr, "||"),
+				token.New(token.TypeIdent, "e"),
+				token.New(token.TypePercent, "%"),
+				token.New(token.TypeIdent, "f"),
+				token.New(token.TypeAmp, "&"),
+				token.New(token.TypeIdent, "g"),
+				token.New(token.TypePipe, "|"),
+				token.New(token.TypeIdent, "h"),
+				token.New(token.TypeCaret, "^"),
+				token.New(token.TypeIdent, "i"),
+				token.New(token.TypeShl, "<<"),
+				token.New(token.TypeIdent, "j"),
+				token.New(token.TypeShr, ">>"),
+				token.New(token.TypeIdent, "k"),
+				token.New(token.TypeLT, "<"),
+				token.New(token.TypeIdent, "l"),
+				token.New(token.TypeGT, ">"),
+				token.New(token.TypeIdent, "m"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Numbers": {
+			input: `1 1.5 1e3 2.5E-3 1e+2 1. 1e x.y 3.e`,
+			toks: []token.Token{
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeFloat, "1.5"),
+				token.New(token.TypeFloat, "1e3"),
+				token.New(token.TypeFloat, "2.5E-3"),
+				token.New(token.TypeFloat, "1e+2"),
+				token.New(token.TypeInt, "1"),
+				token.New(token.TypeDot, "."),
+				token.New(token.TypeIllegal, "1e"),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeDot, "."),
+				token.New(token.TypeIdent, "y"),
+				token.New(token.TypeInt, "3"),
+				token.New(token.TypeDot, "."),
+				token.New(token.TypeIdent, "e"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Integer literals": {
+			input: `0xFF 0o17 0B1010 1_000_000 0x_1f 0755 1_000.5`,
+			toks: []token.Token{
+				token.New(token.TypeInt, "0xFF"),
+				token.New(token.TypeInt, "0o17"),
+				token.New(token.TypeInt, "0B1010"),
+				token.New(token.TypeInt, "1_000_000"),
+				token.New(token.TypeInt, "0x_1f"),
+				token.New(token.TypeInt, "0755"),
+				token.New(token.TypeFloat, "1_000.5"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Bad numbers": {
+			input: `0xZZ 1_ 1__0 0b102 0x 09 1_.5 12ab;`,
+			toks: []token.Token{
+				token.New(token.TypeIllegal, "0xZZ"),
+				token.New(token.TypeIllegal, "1_"),
+				token.New(token.TypeIllegal, "1__0"),
+				token.New(token.TypeIllegal, "0b102"),
+				token.New(token.TypeIllegal, "0x"),
+				token.New(token.TypeIllegal, "09"),
+				token.New(token.TypeIllegal, "1_.5"),
+				token.New(token.TypeIllegal, "12ab"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Loops": {
+			input: `top: for (x in xs) { while (y) { break top; continue; } }`,
+			toks: []token.Token{
+				token.New(token.TypeIdent, "top"),
+				token.New(token.TypeColon, ":"),
+				token.New(token.TypeFor, "for"),
+				token.New(token.TypeLParen, "("),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeIn, "in"),
+				token.New(token.TypeIdent, "xs"),
+				token.New(token.TypeRParen, ")"),
+				token.New(token.TypeLBrace, "{"),
+				token.New(token.TypeWhile, "while"),
+				token.New(token.TypeLParen, "("),
+				token.New(token.TypeIdent, "y"),
+				token.New(token.TypeRParen, ")"),
+				token.New(token.TypeLBrace, "{"),
+				token.New(token.TypeBreak, "break"),
+				token.New(token.TypeIdent, "top"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeContinue, "continue"),
+				token.New(token.TypeSemicolon, ";"),
+				token.New(token.TypeRBrace, "}"),
+				token.New(token.TypeRBrace, "}"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
+		"Macro": {
+			input: `macro(x) { quote(unquote(x)) }`,
+			toks: []token.Token{
+				token.New(token.TypeMacro, "macro"),
+				token.New(token.TypeLParen, "("),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeRParen, ")"),
+				token.New(token.TypeLBrace, "{"),
+				token.New(token.TypeIdent, "quote"),
+				token.New(token.TypeLParen, "("),
+				token.New(token.TypeIdent, "unquote"),
+				token.New(token.TypeLParen, "("),
+				token.New(token.TypeIdent, "x"),
+				token.New(token.TypeRParen, ")"),
+				token.New(token.TypeRParen, ")"),
+				token.New(token.TypeRBrace, "}"),
+				token.New(token.TypeEOF, ""),
+			},
+		},
 	} {
 		tc := tc
 		t.Run(name, func(t *testing.T) {
@@ -169,3 +394,79 @@ if (5 < 10) {
 		})
 	}
 }
+
+func TestLexer_Positions(t *testing.T) {
+	t.Parallel()
+
+	l := lexer.NewFile("main.mmm", "let x = 5;\n\tx + \"ab\";")
+	for _, want := range []struct {
+		lit      string
+		pos, end token.Pos
+	}{
+		{lit: "let", pos: token.Pos{Line: 1, Col: 1, Offset: 0}, end: token.Pos{Line: 1, Col: 4, Offset: 3}},
+		{lit: "x", pos: token.Pos{Line: 1, Col: 5, Offset: 4}, end: token.Pos{Line: 1, Col: 6, Offset: 5}},
+		{lit: "=", pos: token.Pos{Line: 1, Col: 7, Offset: 6}, end: token.Pos{Line: 1, Col: 8, Offset: 7}},
+		{lit: "5", pos: token.Pos{Line: 1, Col: 9, Offset: 8}, end: token.Pos{Line: 1, Col: 10, Offset: 9}},
+		{lit: ";", pos: token.Pos{Line: 1, Col: 10, Offset: 9}, end: token.Pos{Line: 1, Col: 11, Offset: 10}},
+		{lit: "x", pos: token.Pos{Line: 2, Col: 2, Offset: 12}, end: token.Pos{Line: 2, Col: 3, Offset: 13}},
+		{lit: "+", pos: token.Pos{Line: 2, Col: 4, Offset: 14}, end: token.Pos{Line: 2, Col: 5, Offset: 15}},
+		{lit: "ab", pos: token.Pos{Line: 2, Col: 6, Offset: 16}, end: token.Pos{Line: 2, Col: 10, Offset: 20}},
+		{lit: ";", pos: token.Pos{Line: 2, Col: 10, Offset: 20}, end: token.Pos{Line: 2, Col: 11, Offset: 21}},
+		{lit: "", pos: token.Pos{Line: 2, Col: 11, Offset: 21}, end: token.Pos{Line: 2, Col: 11, Offset: 21}},
+	} {
+		want.pos.File, want.end.File = "main.mmm", "main.mmm"
+		got := l.NextToken()
+		is.Equal(t, want.lit, got.Literal())
+		is.Equal(t, want.pos, got.Pos())
+		is.Equal(t, want.end, got.End())
+	}
+}
+
+func TestLexer_ScanComments(t *testing.T) {
+	t.Parallel()
+
+	l := lexer.New("// doc\nlet x = 1; /* a\nb */ x")
+	l.SetMode(lexer.ScanComments)
+	for _, want := range []token.Token{
+		token.New(token.TypeComment, "// doc"),
+		token.New(token.TypeLet, "let"),
+		token.New(token.TypeIdent, "x"),
+		token.New(token.TypeAssign, "="),
+		token.New(token.TypeInt, "1"),
+		token.New(token.TypeSemicolon, ";"),
+		token.New(token.TypeComment, "/* a\nb */"),
+		token.New(token.TypeIdent, "x"),
+		token.New(token.TypeEOF, ""),
+	} {
+		got := l.NextToken()
+		is.Equal(t, want.Type(), got.Type())
+		is.Equal(t, want.Literal(), got.Literal())
+	}
+
+	l = lexer.New("x /* open")
+	l.SetMode(lexer.ScanComments)
+	l.NextToken()
+	is.Equal(t, token.TypeIllegal, l.NextToken().Type())
+	is.Equal(t, "1:3: unterminated comment", l.Errors()[0].Error())
+}
+
+func TestLexer_Errors(t *testing.T) {
+	t.Parallel()
+
+	l := lexer.New("\"a\\qb\" \"\\u{110000}\" \"\\u41\" @\n\xff ∑ `open")
+	for t := l.NextToken(); t.Type() != token.TypeEOF; t = l.NextToken() {
+	}
+	var got []string
+	for _, e := range l.Errors() {
+		got = append(got, e.Error())
+	}
+	is.Equal(t, strings.Join([]string{
+		`1:3: unknown escape sequence \q`,
+		`1:9: invalid Unicode code point \u{110000}`,
+		`1:22: malformed escape sequence, want \u{hex}`,
+		`1:28: unexpected character '@'`,
+		`2:1: invalid UTF-8 encoding`,
+		`2:3: unexpected character '∑'`,
+		`2:7: unterminated raw string`,
+	}, "\n"), strings.Join(got, "\n"))
+}
@@ -1,11 +1,159 @@
 package main
 
 import (
-	"mmm/repl"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"mmm/compiler"
+	"mmm/entity"
+	"mmm/eval"
+	"mmm/lexer"
+	"mmm/module"
+	"mmm/parser"
+	"mmm/repl"
+	"mmm/vm"
+)
+
+// Exit codes of the mmm command.
+const (
+	exitOK = iota
+	// exitFailed is used when the program had parse, compile or runtime errors.
+	exitFailed
+	// exitUsage is used when mmm itself was called incorrectly.
+	exitUsage
 )
 
+// defaultMaxDepth is how deeply calls can nest unless -max-depth says
+// otherwise. It stops runaway recursion with an error well before the eval
+// package runs out of Go stack.
+const defaultMaxDepth = 10000
+
+const usage = `usage: mmm <command> [arguments]
+
+commands:
+	run [-vm] [-max-depth n] file.mmm
+	                    run a script
+	repl [-vm] [-max-depth n] [-history file]
+	                    start an interactive session, the default
+	tokens              print the tokens of every line read from stdin
+	ast                 print the AST of every line read from stdin
+
+-vm compiles to bytecode and runs it on the VM instead of walking the AST.
+-max-depth is how deeply calls can nest, 10000 by default and no limit if 0.
+-history is where the REPL saves its history, ~/.mmm_history by default.
+`
+
 func main() {
-	os.Stdout.Write([]byte("Mmm monkey\n"))
-	repl.Start(os.Stdin, os.Stdout)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run is the mmm command, it returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := "repl"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	useVM := fs.Bool("vm", false, "run on the bytecode VM")
+	maxDepth := fs.Int("max-depth", defaultMaxDepth, "how deeply calls can nest")
+	history := fs.String("history", defaultHistory(), "REPL history file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	switch cmd {
+	case "run":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return exitUsage
+		}
+		return runFile(fs.Arg(0), *useVM, *maxDepth, stdout, stderr)
+	case "repl":
+		fmt.Fprint(stdout, "Mmm monkey\n")
+		opts := repl.Options{VM: *useVM, HistoryFile: *history, Host: &entity.Host{}, MaxDepth: *maxDepth}
+		opts.Host.Output(stdout)
+		if err := repl.Run(stdin, stdout, opts); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailed
+		}
+	case "tokens":
+		if repl.StartLexer(stdin, stdout) != nil {
+			return exitFailed
+		}
+	case "ast":
+		if repl.StartParser(stdin, stdout) != nil {
+			return exitFailed
+		}
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	return exitOK
+}
+
+// runFile executes the script at path with calls nesting at most maxDepth
+// deep, its output is written to stdout and errors to stderr.
+func runFile(path string, useVM bool, maxDepth int, stdout, stderr io.Writer) int {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+	p := parser.New(lexer.NewFile(path, string(src)))
+	prg := p.Parse()
+	if len(p.Errors()) != 0 {
+		for _, e := range p.Errors() {
+			fmt.Fprintln(stderr, e)
+		}
+		return exitFailed
+	}
+	macros := entity.NewEnv()
+	prg, err = eval.ExpandMacros(eval.DefineMacros(prg, macros), macros)
+	if err != nil {
+		fmt.Fprintln(stderr, err.(entity.Error).Traceback())
+		return exitFailed
+	}
+	loader, name := module.FSLoader{FS: os.DirFS(filepath.Dir(path))}, filepath.Base(path)
+	host := &entity.Host{}
+	host.Output(stdout)
+	if useVM {
+		c := compiler.New()
+		c.SetLoader(loader, name)
+		c.SetHost(host)
+		if err := c.Compile(prg); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailed
+		}
+		m := vm.New(c.Bytecode())
+		m.SetMaxDepth(maxDepth)
+		if err := m.Run(); err != nil {
+			if ent, ok := err.(entity.Error); ok {
+				fmt.Fprintln(stderr, ent.Traceback())
+			} else {
+				fmt.Fprintln(stderr, err)
+			}
+			return exitFailed
+		}
+		return exitOK
+	}
+	env := entity.NewEnv().WithImporter(eval.NewImporter(loader, name)).WithHost(host)
+	if err, ok := eval.EvalWith(prg, env, eval.Options{MaxDepth: maxDepth}).(entity.Error); ok {
+		fmt.Fprintln(stderr, err.Traceback())
+		return exitFailed
+	}
+	return exitOK
+}
+
+// defaultHistory is the REPL history file in the user's home directory.
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".mmm_history")
 }
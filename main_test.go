@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mmm/is"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"ok.mmm":         "let add = fn(x, y) { x + y };\nadd(1, 2);\n",
+		"parse.mmm":      "let = 5;\n",
+		"runtime.mmm":    "let x = 1;\nx + true;\n",
+		"import.mmm":     "let lib = import \"lib/double.mmm\";\nif (lib.double(2) != 4) { 1 + true };\n",
+		"lib/double.mmm": "let double = fn(x) { x * 2 };\n",
+		"cycle.mmm":      "import \"cycle.mmm\";\n",
+		"puts.mmm":       "puts(\"hi\");\nprint(1, 2);\n",
+		"deep.mmm":       "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };\nf(15000);\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		args    []string
+		stdin   string
+		code    int
+		wantOut string
+		wantErr string
+	}{
+		"Run":              {args: []string{"run", "ok.mmm"}, code: exitOK},
+		"Run on VM":        {args: []string{"run", "-vm", "ok.mmm"}, code: exitOK},
+		"Parse error":      {args: []string{"run", "parse.mmm"}, code: exitFailed, wantErr: "parse.mmm:1:5: expected next token to be Ident, got Assign"},
+		"Runtime error":    {args: []string{"run", "runtime.mmm"}, code: exitFailed, wantErr: "runtime.mmm:2:1: type mismatch: Int + Bool"},
+		"Runtime error VM": {args: []string{"run", "-vm", "runtime.mmm"}, code: exitFailed, wantErr: "runtime.mmm:2:1: type mismatch: Int + Bool"},
+		"Missing file":     {args: []string{"run", "nope.mmm"}, code: exitFailed, wantErr: "no such file"},
+		"Import":           {args: []string{"run", "import.mmm"}, code: exitOK},
+		"Import on VM":     {args: []string{"run", "-vm", "import.mmm"}, code: exitOK},
+		"Import cycle":     {args: []string{"run", "cycle.mmm"}, code: exitFailed, wantErr: "import cycle: cycle.mmm -> cycle.mmm"},
+		"Import cycle VM":  {args: []string{"run", "-vm", "cycle.mmm"}, code: exitFailed, wantErr: "import cycle: cycle.mmm -> cycle.mmm"},
+		"Output":           {args: []string{"run", "puts.mmm"}, code: exitOK, wantOut: "hi\n1 2"},
+		"Output on VM":     {args: []string{"run", "-vm", "puts.mmm"}, code: exitOK, wantOut: "hi\n1 2"},
+		"Too deep":         {args: []string{"run", "deep.mmm"}, code: exitFailed, wantErr: "deep.mmm:1:46: call depth limit exceeded: 10000"},
+		"Too deep VM":      {args: []string{"run", "-vm", "deep.mmm"}, code: exitFailed, wantErr: "deep.mmm:1:46: call depth limit exceeded: 10000"},
+		"Max depth":        {args: []string{"run", "-max-depth", "20000", "deep.mmm"}, code: exitOK},
+		"Max depth VM":     {args: []string{"run", "-vm", "-max-depth", "20000", "deep.mmm"}, code: exitOK},
+		"Run without file": {args: []string{"run"}, code: exitUsage, wantErr: "usage"},
+		"Unknown command":  {args: []string{"nope"}, code: exitUsage, wantErr: "usage"},
+		"Tokens":           {args: []string{"tokens"}, stdin: "let x = 1;", code: exitOK},
+		"Illegal tokens":   {args: []string{"tokens"}, stdin: "let x = @;", code: exitFailed},
+		"AST":              {args: []string{"ast"}, stdin: "1 + 2", code: exitOK},
+		"AST parse error":  {args: []string{"ast"}, stdin: "let = 5;", code: exitFailed},
+		"REPL":             {args: []string{"repl", "-history", ""}, stdin: "1 + 2", code: exitOK},
+		"REPL is default":  {args: []string{"-history", ""}, stdin: "1 + 2", code: exitOK},
+		"REPL max depth":   {args: []string{"repl", "-history", "", "-max-depth", "5"}, stdin: "let f = fn(n) { 1 + f(n) }; f(1)", code: exitOK, wantOut: "call depth limit exceeded: 5"},
+		"REPL output":      {args: []string{"repl", "-history", ""}, stdin: `puts("hi")`, code: exitOK, wantOut: "hi\n"},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			for i, a := range tc.args {
+				if strings.HasSuffix(a, ".mmm") {
+					tc.args[i] = filepath.Join(dir, a)
+				}
+			}
+			var stdout, stderr bytes.Buffer
+			code := run(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr)
+			is.Equal(t, tc.code, code)
+			if !strings.Contains(stdout.String(), tc.wantOut) {
+				t.Fatalf("stdout %q does not contain %q", stdout.String(), tc.wantOut)
+			}
+			if !strings.Contains(stderr.String(), tc.wantErr) {
+				t.Fatalf("stderr %q does not contain %q", stderr.String(), tc.wantErr)
+			}
+		})
+	}
+}
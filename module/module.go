@@ -0,0 +1,96 @@
+// package module finds and parses the modules a program imports. A module is a
+// file of mmm code whose bindings are exported to the programs importing it,
+// except for the names starting with an underscore which are private to it.
+//
+// Modules are named by slash separated paths relative to the root of a Loader,
+// which is the directory of the program being run when mmm runs a file.
+package module
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"mmm/ast"
+	"mmm/lexer"
+	"mmm/parser"
+)
+
+// Loader finds the source of modules, it's how an embedder decides where
+// imported modules come from.
+type Loader interface {
+	// Load returns the source of the module called name, a path as returned by
+	// Resolve.
+	Load(name string) (string, error)
+}
+
+// FSLoader loads modules from the files of FS.
+type FSLoader struct {
+	FS fs.FS
+}
+
+func (l FSLoader) Load(name string) (string, error) {
+	src, err := fs.ReadFile(l.FS, name)
+	return string(src), err
+}
+
+// Resolve returns the name of the module imported as p by the module called
+// from. A relative p is relative to the directory of from, while an absolute p
+// is relative to the root of the Loader. from is empty for a program that isn't
+// a module itself, like the input of the REPL.
+func Resolve(from, p string) (string, error) {
+	var name string
+	if strings.HasPrefix(p, "/") {
+		name = path.Clean(p[1:])
+	} else {
+		name = path.Join(path.Dir(from), p)
+	}
+	if p == "" || !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid import path %q", p)
+	}
+	return name, nil
+}
+
+// Load reads the module called name from l and parses it.
+func Load(l Loader, name string) (ast.Program, error) {
+	src, err := l.Load(name)
+	if err != nil {
+		return ast.Program{}, fmt.Errorf("cannot import %q: %w", name, err)
+	}
+	p := parser.New(lexer.NewFile(name, src))
+	prg := p.Parse()
+	if errs := p.Errors(); len(errs) != 0 {
+		return prg, errors.New(strings.Join(errs, "\n"))
+	}
+	return prg, nil
+}
+
+// Exported reports whether the binding called name is visible to the programs
+// importing its module.
+func Exported(name string) bool { return !strings.HasPrefix(name, "_") }
+
+// CycleError is a module importing itself, directly or through the modules it
+// imports.
+type CycleError struct {
+	// Imports are the modules of the cycle in the order they're imported,
+	// starting and ending with the same module.
+	Imports []string
+}
+
+func (e CycleError) Error() string {
+	return "import cycle: " + strings.Join(e.Imports, " -> ")
+}
+
+// Cycle returns the CycleError of importing name while the modules in loading
+// are being loaded, the outermost first, or nil when there's no cycle.
+func Cycle(loading []string, name string) error {
+	for i, l := range loading {
+		if l == name {
+			imports := append(loading[i:len(loading):len(loading)], name)
+			return CycleError{Imports: imports}
+		}
+	}
+	return nil
+}
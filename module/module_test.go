@@ -0,0 +1,86 @@
+package module_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"mmm/is"
+	"mmm/module"
+)
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+	for name, tc := range map[string]struct {
+		from, path string
+		want       string
+		wantErr    string
+	}{
+		"From the REPL":   {from: "", path: "lib.mmm", want: "lib.mmm"},
+		"Sibling":         {from: "main.mmm", path: "lib.mmm", want: "lib.mmm"},
+		"Subdirectory":    {from: "main.mmm", path: "lib/math.mmm", want: "lib/math.mmm"},
+		"Relative":        {from: "lib/math.mmm", path: "helper.mmm", want: "lib/helper.mmm"},
+		"Parent":          {from: "lib/math.mmm", path: "../main.mmm", want: "main.mmm"},
+		"Absolute":        {from: "lib/math.mmm", path: "/main.mmm", want: "main.mmm"},
+		"Cleaned":         {from: "", path: "./lib//math.mmm", want: "lib/math.mmm"},
+		"Outside of root": {from: "main.mmm", path: "../main.mmm", wantErr: `invalid import path "../main.mmm"`},
+		"Empty":           {from: "main.mmm", path: "", wantErr: `invalid import path ""`},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := module.Resolve(tc.from, tc.path)
+			if tc.wantErr != "" {
+				is.Equal(t, tc.wantErr, err.Error())
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			is.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	l := module.FSLoader{FS: fstest.MapFS{
+		"ok.mmm":  {Data: []byte("let x = 1;")},
+		"bad.mmm": {Data: []byte("let x = 1;\nlet = 2;")},
+	}}
+	for name, tc := range map[string]struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		"Parsed":      {name: "ok.mmm", want: "let x = 1;"},
+		"Parse error": {name: "bad.mmm", wantErr: "bad.mmm:2:5: expected next token to be Ident, got Assign"},
+		"Missing":     {name: "nope.mmm", wantErr: `cannot import "nope.mmm": open nope.mmm: file does not exist`},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			prg, err := module.Load(l, tc.name)
+			if tc.wantErr != "" {
+				is.Equal(t, tc.wantErr, err.Error())
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			is.Equal(t, tc.want, prg.String())
+		})
+	}
+}
+
+func TestCycle(t *testing.T) {
+	t.Parallel()
+	is.Equal(t, nil, module.Cycle([]string{"a.mmm", "b.mmm"}, "c.mmm"))
+	is.Equal(t, "import cycle: b.mmm -> c.mmm -> b.mmm",
+		module.Cycle([]string{"a.mmm", "b.mmm", "c.mmm"}, "b.mmm").Error())
+}
+
+func TestExported(t *testing.T) {
+	t.Parallel()
+	is.Equal(t, true, module.Exported("double"))
+	is.Equal(t, false, module.Exported("_count"))
+}
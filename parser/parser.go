@@ -2,6 +2,8 @@ package parser
 
 import (
 	"errors"
+	"fmt"
+	"math/big"
 	"mmm/ast"
 	"mmm/lexer"
 	"mmm/token"
@@ -13,10 +15,16 @@ type priority uint8
 const (
 	_ priority = iota
 	priorityLowest
+	priorityOr // priorityOr e.g. x || y
+	priorityAnd // priorityAnd e.g. x && y
 	priorityEquals // priorityEquals e.g. x == x
-	priorityLessGreater // priorityLessGreater e.g. < or >
+	priorityLessGreater // priorityLessGreater e.g. < or >=
+	priorityBitOr // priorityBitOr e.g. x | y
+	priorityBitXor // priorityBitXor e.g. x ^ y
+	priorityBitAnd // priorityBitAnd e.g. x & y
+	priorityShift // priorityShift e.g. x << 2
 	prioritySum
-	priorityProduct
+	priorityProduct // priorityProduct e.g. *, / or %
 	priorityPrefix // priorityPrefix e.g. -x, !x
 	priorityCall // priorityCall e.g. call(x)
 	priorityIndex // priorityIndex e.g. slice[1]
@@ -31,14 +39,32 @@ type (
 	infixParseFunc func(ast.Expr) ast.Expr
 )
 
+// Error is a problem the Parser found at a position in the source.
+type Error struct {
+	Pos token.Pos
+	Msg string
+}
+
+func (e Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
+	}
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// TokenError is reported when the Parser expected a different token than the
+// one it found at pos.
 type TokenError struct {
+	pos  token.Pos
 	want token.Type
 	got  token.Type
 }
 
+func (e TokenError) Pos() token.Pos { return e.pos }
+
 func (e TokenError) Error() string {
-	return "expected next token to be " + e.want.String() +
-		", got " + e.got.String()
+	return Error{Pos: e.pos, Msg: "expected next token to be " + e.want.String() +
+		", got " + e.got.String()}.Error()
 }
 
 // Parser parses tokens passed to it by the [*lexer.Lexer] and ultimately
@@ -53,6 +79,13 @@ type Parser struct {
 	// errs is all of the errors found while trying to parse statements to a
 	// program.
 	errs []error
+	// failed is set by the first error in a statement, any errors after it are
+	// usually caused by the first so they aren't reported until the Parser has
+	// synchronised at the start of the next statement.
+	failed bool
+	// loops are the labels of the loops enclosing the statement being parsed,
+	// innermost last, with "" for loops without a label.
+	loops []string
 	prefixes func(token.Type)prefixParseFunc
 	infixes func(token.Type)infixParseFunc
 	priorities func(token.Type)priority
@@ -66,18 +99,30 @@ func New(l *lexer.Lexer) *Parser {
 		switch t {
 		case token.TypeIdent:
 			return func() ast.Expr {
-
-				return ast.NewIdent(p.ctok.Literal())
+				return ast.NewIdent(p.ctok)
 			}
 		case token.TypeInt:
 			return func() ast.Expr {
 				v, err := strconv.ParseInt(p.ctok.Literal(), 0,64)
+				if errors.Is(err, strconv.ErrRange) {
+					if v, ok := new(big.Int).SetString(p.ctok.Literal(), 0); ok {
+						return ast.NewBigInteger(p.ctok, v)
+					}
+				}
 				if err != nil {
-					p.errs = append(p.errs, errors.New("could not parse "+
-					p.ctok.Type().String() + " as integer"))
+					p.errorf(p.ctok.Pos(), "could not parse %q as integer", p.ctok.Literal())
 					return nil
 				}
-				return ast.NewInteger(v)
+				return ast.NewInteger(p.ctok, v)
+			}
+		case token.TypeFloat:
+			return func() ast.Expr {
+				v, err := strconv.ParseFloat(p.ctok.Literal(), 64)
+				if err != nil {
+					p.errorf(p.ctok.Pos(), "could not parse %q as float", p.ctok.Literal())
+					return nil
+				}
+				return ast.NewFloat(p.ctok, v)
 			}
 		case token.TypeBang, token.TypeMinus:
 			return func() ast.Expr {
@@ -87,7 +132,7 @@ func New(l *lexer.Lexer) *Parser {
 			}
 		case token.TypeBool:
 			return func() ast.Expr {
-				return ast.NewBool(p.ctok.Literal() == "true")
+				return ast.NewBool(p.ctok, p.ctok.Literal() == "true")
 			}
 		case token.TypeLParen:
 			return func() ast.Expr {
@@ -100,6 +145,7 @@ func New(l *lexer.Lexer) *Parser {
 			}
 		case token.TypeIf:
 			return func() ast.Expr { // if 
+				t := p.ctok
 				if !p.peek(token.TypeLParen) { // (
 					return nil
 				}
@@ -122,10 +168,11 @@ func New(l *lexer.Lexer) *Parser {
 					blk := p.parseBlock()
 					alt = &blk
 				}
-				return ast.NewIfExpr(cond, consq, alt)
+				return ast.NewIfExpr(t, cond, consq, alt)
 			}
-		case token.TypeFn:
+		case token.TypeFn, token.TypeMacro:
 			return func() ast.Expr {
+				t := p.ctok
 				if !p.peek(token.TypeLParen) {
 					return nil
 				}
@@ -133,15 +180,55 @@ func New(l *lexer.Lexer) *Parser {
 				if !p.peek(token.TypeLBrace) {
 					return nil
 				}
-				return ast.NewFunction(params, p.parseBlock())
+				// A break or continue can't reach the loops outside a function.
+				loops := p.loops
+				p.loops = nil
+				body := p.parseBlock()
+				p.loops = loops
+				if t.Type() == token.TypeMacro {
+					return ast.NewMacroLiteral(t, params, body)
+				}
+				return ast.NewFunction(t, params, body)
 			}
 		case token.TypeString:
 			return func() ast.Expr {
-				return ast.NewString(p.ctok.Literal())
+				return ast.NewString(p.ctok)
+			}
+		case token.TypeImport:
+			return func() ast.Expr {
+				t := p.ctok
+				if !p.peek(token.TypeString) {
+					return nil
+				}
+				return ast.NewImport(t, ast.NewString(p.ctok))
 			}
 		case token.TypeLBrakt:
 			return func() ast.Expr {
-				return ast.NewSlice(p.parseExprSlice(token.TypeRBrakt)...)
+				t := p.ctok
+				vals := p.parseExprSlice(token.TypeRBrakt)
+				return ast.NewSlice(t, p.ctok, vals...)
+			}
+		case token.TypeLBrace:
+			return func() ast.Expr {
+				t := p.ctok
+				var pairs []ast.Pair
+				for p.ntok.Type() != token.TypeRBrace {
+					p.nextToken()
+					key := p.parseExpression(priorityLowest)
+					if !p.peek(token.TypeColon) {
+						return nil
+					}
+					p.nextToken()
+					pairs = append(pairs, ast.Pair{
+						Key:   key,
+						Value: p.parseExpression(priorityLowest),
+					})
+					if p.ntok.Type() != token.TypeRBrace && !p.peek(token.TypeComma) {
+						return nil
+					}
+				}
+				p.nextToken()
+				return ast.NewHash(t, p.ctok, pairs...)
 			}
 		default:
 			return nil
@@ -150,7 +237,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.infixes = func(t token.Type) infixParseFunc {
 		switch t {
 		case token.TypeEQ, token.TypeNotEQ, token.TypeLT, token.TypeGT,
-		token.TypePlus, token.TypeMinus, token.TypeSlash, token.TypeStar:
+		token.TypePlus, token.TypeMinus, token.TypeSlash, token.TypeStar,
+		token.TypeLTEQ, token.TypeGTEQ, token.TypeAnd, token.TypeOr,
+		token.TypePercent, token.TypeAmp, token.TypePipe, token.TypeCaret,
+		token.TypeShl, token.TypeShr:
 			return func(left ast.Expr) ast.Expr {
 				t, pri := p.ctok, p.priorities(p.ctok.Type())
 				p.nextToken()
@@ -160,18 +250,31 @@ func New(l *lexer.Lexer) *Parser {
 			// This isn't actually an infix operator, but if fits nicely with what we
 			// would like to see from a call, e.g. blah(1, 2, 3, 4)
 			return func(fn ast.Expr) ast.Expr {
-				return ast.NewCallExpr(fn, p.parseExprSlice(token.TypeRParen))
+				t := p.ctok
+				args := p.parseExprSlice(token.TypeRParen)
+				return ast.NewCallExpr(t, fn, args, p.ctok)
 			}
 		case token.TypeLBrakt:
 			// This isn't actually an infix operator, but if fits nicely with what we
 			// would like to see from an index into a slice, e.g. blah[1]
 			return func(e ast.Expr) ast.Expr {
+				t := p.ctok
 				p.nextToken()
 				idx := p.parseExpression(priorityLowest)
 				if !p.peek(token.TypeRBrakt) {
 					return nil
 				}
-				return ast.NewIndex(e, idx)
+				return ast.NewIndex(t, e, idx, p.ctok)
+			}
+		case token.TypeDot:
+			// A selector like lib.name is sugar for lib["name"].
+			return func(e ast.Expr) ast.Expr {
+				t := p.ctok
+				if !p.peek(token.TypeIdent) {
+					return nil
+				}
+				name := token.New(token.TypeString, p.ctok.Literal()).At(p.ctok.Pos(), p.ctok.End())
+				return ast.NewIndex(t, e, ast.NewString(name), p.ctok)
 			}
 		default:
 			return nil
@@ -179,17 +282,29 @@ func New(l *lexer.Lexer) *Parser {
 	}
 	p.priorities = func(t token.Type) priority {
 		switch t {
+		case token.TypeOr:
+			return priorityOr
+		case token.TypeAnd:
+			return priorityAnd
 		case token.TypeEQ, token.TypeNotEQ:
 			return priorityEquals
-		case token.TypeLT, token.TypeGT:
+		case token.TypeLT, token.TypeGT, token.TypeLTEQ, token.TypeGTEQ:
 			return priorityLessGreater
+		case token.TypePipe:
+			return priorityBitOr
+		case token.TypeCaret:
+			return priorityBitXor
+		case token.TypeAmp:
+			return priorityBitAnd
+		case token.TypeShl, token.TypeShr:
+			return priorityShift
 		case token.TypePlus, token.TypeMinus:
 			return prioritySum
-		case token.TypeSlash, token.TypeStar:
+		case token.TypeSlash, token.TypeStar, token.TypePercent:
 			return priorityProduct
 		case token.TypeLParen:
 			return priorityCall
-		case token.TypeLBrakt:
+		case token.TypeLBrakt, token.TypeDot:
 			return priorityIndex
 		default:
 			return priorityLowest
@@ -208,25 +323,88 @@ func (p Parser) Errors() []string {
 	return s
 }
 
-// Parse creates a [*ast.Program] out of the tokens the Parser has.
+// Parse creates a [*ast.Program] out of the tokens the Parser has. When there
+// are Errors the program is partial, it has every statement that could be
+// parsed.
 func (p *Parser) Parse() ast.Program {
 	var program ast.Program
 	for p.ctok.Type() != token.TypeEOF {
-		if s := p.parseStatement(); s != nil {
+		if s, ok := p.parseStatementOrSync(token.TypeEOF); ok {
 			program.Statements = append(program.Statements, s)
 		}
-		p.nextToken()
 	}
 	return program
 }
 
+// parseStatementOrSync parses a statement and moves on to the token after it.
+// If the statement had an error it isn't ok and the Parser skips to the start
+// of the next statement or the closing end token of the block it's in.
+func (p *Parser) parseStatementOrSync(end token.Type) (ast.Statement, bool) {
+	start := p.ctok
+	s := p.parseStatement()
+	if !p.failed {
+		p.nextToken()
+		return s, s != nil
+	}
+	p.sync(start, end)
+	return nil, false
+}
+
+// sync skips the tokens of a statement that failed to parse. It stops on the
+// token after a semicolon, or after the braces the statement opened are closed
+// along with a semicolon right after them. It also stops on a keyword that
+// starts a new statement, and on end when it isn't nested inside braces the
+// statement opened.
+func (p *Parser) sync(start token.Token, end token.Type) {
+	defer func() { p.failed = false }()
+	depth := 0
+	for {
+		switch p.ctok.Type() {
+		case token.TypeEOF:
+			return
+		case token.TypeLBrace:
+			depth++
+		case token.TypeRBrace:
+			if depth == 0 && end == token.TypeRBrace {
+				return
+			}
+			if depth--; depth == 0 {
+				p.nextToken()
+				if p.ctok.Type() == token.TypeSemicolon {
+					p.nextToken()
+				}
+				return
+			}
+		case token.TypeSemicolon:
+			if depth <= 0 {
+				p.nextToken()
+				return
+			}
+		case token.TypeLet, token.TypeReturn, token.TypeWhile, token.TypeFor,
+			token.TypeBreak, token.TypeContinue:
+			if depth <= 0 && p.ctok.Pos() != start.Pos() {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
+	if p.ctok.Type() == token.TypeIdent && p.ntok.Type() == token.TypeColon {
+		return p.parseLabelled()
+	}
 	switch p.ctok.Type() {
+	case token.TypeWhile, token.TypeFor:
+		return p.parseLoop("")
+	case token.TypeBreak, token.TypeContinue:
+		return p.parseBranch()
 	case token.TypeLet:
+		t := p.ctok
 		if !p.peek(token.TypeIdent) {
 			return nil
 		}
-		id := ast.NewIdent(p.ctok.Literal())
+		id := ast.NewIdent(p.ctok)
 		if !p.peek(token.TypeAssign) {
 			return nil
 		}
@@ -235,16 +413,22 @@ func (p *Parser) parseStatement() ast.Statement {
 		if p.ntok.Type() == token.TypeSemicolon {
 			p.nextToken()
 		}
-		return ast.NewLetStmt(id, expr)
+		return ast.NewLetStmt(t, id, expr)
 	case token.TypeReturn:
+		t := p.ctok
 		p.nextToken()
 		expr := p.parseExpression(priorityLowest)
 		if p.ntok.Type() == token.TypeSemicolon {
 			p.nextToken()
 		}
-		return ast.NewRetStmt(expr)
+		return ast.NewRetStmt(t, expr)
 	default:
-		es := ast.NewExprStmt(p.ctok, p.parseExpression(priorityLowest))
+		t := p.ctok
+		expr := p.parseExpression(priorityLowest)
+		if isAssign(p.ntok.Type()) {
+			return p.parseAssign(expr)
+		}
+		es := ast.NewExprStmt(t, expr)
 		if p.ntok.Type() == token.TypeSemicolon {
 			p.nextToken()
 		}
@@ -252,11 +436,131 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
+func isAssign(t token.Type) bool {
+	switch t {
+	case token.TypeAssign, token.TypePlusAssign, token.TypeMinusAssign,
+		token.TypeStarAssign, token.TypeSlashAssign:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseAssign parses the rest of an assignment to target, the next token is
+// the assignment operator.
+func (p *Parser) parseAssign(target ast.Expr) ast.Statement {
+	p.nextToken()
+	op := p.ctok
+	switch target.(type) {
+	case ast.Ident, ast.Index:
+	case nil:
+		return nil
+	default:
+		p.errorf(target.Pos(), "cannot assign to %s", target)
+		return nil
+	}
+	p.nextToken()
+	value := p.parseExpression(priorityLowest)
+	if p.ntok.Type() == token.TypeSemicolon {
+		p.nextToken()
+	}
+	return ast.NewAssignStmt(op, target, value)
+}
+
+// parseLabelled parses a loop with a label in front of it e.g.
+//
+//	outer: for (x in xs) { ... }
+func (p *Parser) parseLabelled() ast.Statement {
+	label := p.ctok
+	p.nextToken() // :
+	if t := p.ntok.Type(); t != token.TypeWhile && t != token.TypeFor {
+		p.errorf(label.Pos(), "label %s must be followed by a loop, got %s",
+			label.Literal(), t)
+		return nil
+	}
+	for _, l := range p.loops {
+		if l == label.Literal() {
+			p.errorf(label.Pos(), "label %s is already defined", label.Literal())
+			return nil
+		}
+	}
+	p.nextToken()
+	return p.parseLoop(label.Literal())
+}
+
+// parseLoop parses a while or for loop, the current token is the keyword.
+func (p *Parser) parseLoop(label string) ast.Statement {
+	t := p.ctok
+	if !p.peek(token.TypeLParen) {
+		return nil
+	}
+	var v ast.Ident
+	if t.Type() == token.TypeFor {
+		if !p.peek(token.TypeIdent) {
+			return nil
+		}
+		v = ast.NewIdent(p.ctok)
+		if !p.peek(token.TypeIn) {
+			return nil
+		}
+	}
+	p.nextToken()
+	expr := p.parseExpression(priorityLowest)
+	if !p.peek(token.TypeRParen) {
+		return nil
+	}
+	if !p.peek(token.TypeLBrace) {
+		return nil
+	}
+	p.loops = append(p.loops, label)
+	body := p.parseBlock()
+	p.loops = p.loops[:len(p.loops)-1]
+	if p.ntok.Type() == token.TypeSemicolon {
+		p.nextToken()
+	}
+	if t.Type() == token.TypeFor {
+		return ast.NewForStmt(t, label, v, expr, body)
+	}
+	return ast.NewWhileStmt(t, label, expr, body)
+}
+
+// parseBranch parses a break or continue with an optional label.
+func (p *Parser) parseBranch() ast.Statement {
+	t := p.ctok
+	label, end := "", t.End()
+	if p.ntok.Type() == token.TypeIdent {
+		p.nextToken()
+		label, end = p.ctok.Literal(), p.ctok.End()
+	}
+	if len(p.loops) == 0 {
+		p.errorf(t.Pos(), "%s is not in a loop", t.Literal())
+		return nil
+	}
+	if label != "" {
+		found := false
+		for _, l := range p.loops {
+			found = found || l == label
+		}
+		if !found {
+			p.errorf(t.Pos(), "%s to undefined label %s", t.Literal(), label)
+			return nil
+		}
+	}
+	if p.ntok.Type() == token.TypeSemicolon {
+		p.nextToken()
+	}
+	return ast.NewBranchStmt(t, label, end)
+}
+
 func (p *Parser) parseExpression(pr priority) ast.Expr {
+	if p.ctok.Type() == token.TypeIllegal {
+		p.illegal(p.ctok)
+		return nil
+	}
 	prefix := p.prefixes(p.ctok.Type())
 	if prefix == nil {
-		p.errs = append(p.errs, errors.New("no prefix parse function for " +
-p.ctok.Type().String() + " found"))
+		p.errorf(p.ctok.Pos(), "no prefix parse function for %s found",
+			p.ctok.Type())
 		return nil
 	}
 	left := prefix()
@@ -273,15 +577,15 @@ p.ctok.Type().String() + " found"))
 
 // parseBlock consumes a block statment defined as { ... }.
 func (p *Parser) parseBlock() ast.BlockStmt {
+	lbrace := p.ctok
 	p.nextToken()
 	var ss []ast.Statement
 	for p.ctok.Type() != token.TypeRBrace && p.ctok.Type() != token.TypeEOF {
-		if s := p.parseStatement(); s != nil {
+		if s, ok := p.parseStatementOrSync(token.TypeRBrace); ok {
 			ss = append(ss, s)
 		}
-		p.nextToken()
 	}
-	return ast.NewBlockStmt(ss)
+	return ast.NewBlockStmt(lbrace, ss, p.ctok)
 }
 
 func (p *Parser) parseFnParams() []ast.Ident {
@@ -292,11 +596,11 @@ func (p *Parser) parseFnParams() []ast.Ident {
 	}
 	p.nextToken() // x
 	// (x, y)
-	idents = append(idents, ast.NewIdent(p.ctok.Literal()))
+	idents = append(idents, ast.NewIdent(p.ctok))
 	for p.ntok.Type() == token.TypeComma {
 		p.nextToken() // ,
 		p.nextToken() // y
-		idents = append(idents, ast.NewIdent(p.ctok.Literal()))
+		idents = append(idents, ast.NewIdent(p.ctok))
 	}
 	if !p.peek(token.TypeRParen) {
 		return nil
@@ -307,11 +611,45 @@ func (p *Parser) parseFnParams() []ast.Ident {
 func (p *Parser) nextToken() {
 	p.ctok = p.ntok
 	p.ntok = p.l.NextToken()
+	// Comments mean nothing to the Parser, they're only there when the Lexer
+	// was asked to keep them.
+	for p.ntok.Type() == token.TypeComment {
+		p.ntok = p.l.NextToken()
+	}
+}
+
+func (p *Parser) errorf(pos token.Pos, format string, a ...any) {
+	p.report(Error{Pos: pos, Msg: fmt.Sprintf(format, a...)})
+}
+
+// report records err unless the statement being parsed already failed.
+func (p *Parser) report(err error) {
+	if p.failed {
+		return
+	}
+	p.failed = true
+	p.errs = append(p.errs, err)
+}
+
+// illegal reports why the Lexer made t an Illegal token.
+func (p *Parser) illegal(t token.Token) {
+	for _, e := range p.l.Errors() {
+		if e.Pos.File == t.Pos().File && t.Pos().Offset <= e.Pos.Offset &&
+			e.Pos.Offset < t.End().Offset {
+			p.report(Error{Pos: e.Pos, Msg: e.Msg})
+			return
+		}
+	}
+	p.errorf(t.Pos(), "illegal token %q", t.Literal())
 }
 
 func (p *Parser) peek(t token.Type) bool {
+	if p.ntok.Type() == token.TypeIllegal {
+		p.illegal(p.ntok)
+		return false
+	}
 	if got := p.ntok.Type(); got != t {
-		p.errs = append(p.errs, TokenError{want: t, got: got})
+		p.report(TokenError{pos: p.ntok.Pos(), want: t, got: got})
 		return false
 	}
 	p.nextToken()
@@ -319,7 +657,7 @@ func (p *Parser) peek(t token.Type) bool {
 }
 
 func (p *Parser) parseExprSlice(end token.Type) []ast.Expr {
-	if p.ntok.Type() == token.TypeRParen {
+	if p.ntok.Type() == end {
 		p.nextToken()
 		return nil
 	}
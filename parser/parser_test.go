@@ -5,6 +5,8 @@ import (
 	"mmm/is"
 	"mmm/lexer"
 	"mmm/parser"
+	"mmm/token"
+	"strings"
 	"testing"
 )
 
@@ -56,6 +58,46 @@ return 9320812;`))
 		ident := stmt.Expression().(ast.Integer)
 		is.Equal(t, "5", ident.TokenLiteral())
 	})
+	t.Run("Integer Literal Forms", func(t *testing.T) {
+		t.Parallel()
+		for input, want := range map[string]int64{
+			"0xff":      255,
+			"0o17":      15,
+			"0b1010":    10,
+			"1_000_000": 1000000,
+			"0755":      493,
+		} {
+			input, want := input, want
+			t.Run(input, func(t *testing.T) {
+				t.Parallel()
+				p := parser.New(lexer.New(input))
+				program := p.Parse()
+				checkErrors(t, p.Errors())
+				stmt := program.Statements[0].(ast.ExprStmt)
+				is.Equal(t, want, stmt.Expression().(ast.Integer).Value())
+			})
+		}
+	})
+	t.Run("Float Literal", func(t *testing.T) {
+		t.Parallel()
+		p := parser.New(lexer.New(`2.5e-1;`))
+		program := p.Parse()
+		checkErrors(t, p.Errors())
+		stmt := program.Statements[0].(ast.ExprStmt)
+		f := stmt.Expression().(ast.Float)
+		is.Equal(t, "2.5e-1", f.TokenLiteral())
+		is.Equal(t, 0.25, f.Value())
+	})
+	t.Run("Big Integer Literal", func(t *testing.T) {
+		t.Parallel()
+		p := parser.New(lexer.New(`9223372036854775808;`))
+		program := p.Parse()
+		checkErrors(t, p.Errors())
+		stmt := program.Statements[0].(ast.ExprStmt)
+		i := stmt.Expression().(ast.Integer)
+		is.Equal(t, "9223372036854775808", i.TokenLiteral())
+		is.Equal(t, "9223372036854775808", i.Big().String())
+	})
 	t.Run("Parse Prefix Int Expressions", func(t *testing.T) {
 		t.Parallel()
 		for name, tc := range map[string]struct {
@@ -112,14 +154,22 @@ return 9320812;`))
 			operator string
 			right    int64
 		}{
-			"Plus":         {input: "5 + 5;", left: 5, operator: "+", right: 5},
-			"Minus":        {input: "5 - 5;", left: 5, operator: "-", right: 5},
-			"Star":         {input: "5 * 5;", left: 5, operator: "*", right: 5},
-			"Slash":        {input: "5 / 5;", left: 5, operator: "/", right: 5},
-			"Greater Than": {input: "5 > 5;", left: 5, operator: ">", right: 5},
-			"Less Than":    {input: "5 < 5;", left: 5, operator: "<", right: 5},
-			"Equal":        {input: "5 == 5;", left: 5, operator: "==", right: 5},
-			"Not Equal":    {input: "5 != 5;", left: 5, operator: "!=", right: 5},
+			"Plus":          {input: "5 + 5;", left: 5, operator: "+", right: 5},
+			"Minus":         {input: "5 - 5;", left: 5, operator: "-", right: 5},
+			"Star":          {input: "5 * 5;", left: 5, operator: "*", right: 5},
+			"Slash":         {input: "5 / 5;", left: 5, operator: "/", right: 5},
+			"Greater Than":  {input: "5 > 5;", left: 5, operator: ">", right: 5},
+			"Less Than":     {input: "5 < 5;", left: 5, operator: "<", right: 5},
+			"Equal":         {input: "5 == 5;", left: 5, operator: "==", right: 5},
+			"Not Equal":     {input: "5 != 5;", left: 5, operator: "!=", right: 5},
+			"Less Equal":    {input: "5 <= 5;", left: 5, operator: "<=", right: 5},
+			"Greater Equal": {input: "5 >= 5;", left: 5, operator: ">=", right: 5},
+			"Percent":       {input: "5 % 5;", left: 5, operator: "%", right: 5},
+			"Bit And":       {input: "5 & 5;", left: 5, operator: "&", right: 5},
+			"Bit Or":        {input: "5 | 5;", left: 5, operator: "|", right: 5},
+			"Bit Xor":       {input: "5 ^ 5;", left: 5, operator: "^", right: 5},
+			"Shift Left":    {input: "5 << 5;", left: 5, operator: "<<", right: 5},
+			"Shift Right":   {input: "5 >> 5;", left: 5, operator: ">>", right: 5},
 		} {
 			tc := tc
 			t.Run(name, func(t *testing.T) {
@@ -147,6 +197,8 @@ return 9320812;`))
 			"True is True":      {input: "true == true", left: true, operator: "==", right: true},
 			"True is not False": {input: "true != false", left: true, operator: "!=", right: false},
 			"False is False":    {input: "false == false;", left: false, operator: "==", right: false},
+			"And":               {input: "true && false", left: true, operator: "&&", right: false},
+			"Or":                {input: "false || true", left: false, operator: "||", right: true},
 		} {
 			tc := tc
 			t.Run(name, func(t *testing.T) {
@@ -202,6 +254,22 @@ return 9320812;`))
 				input: "5 * 3 + 2 > 1 == 1 < 2 * 3 + 5",
 				want:  "((((5 * 3) + 2) > 1) == (1 < ((2 * 3) + 5)))",
 			},
+			"Logical operators": {
+				input: "a || b && c || !d",
+				want:  "((a || (b && c)) || (!d))",
+			},
+			"Logical below comparisons": {
+				input: "a <= b && c >= d == e",
+				want:  "((a <= b) && ((c >= d) == e))",
+			},
+			"Bitwise operators": {
+				input: "a | b ^ c & d << 1 + e % 2",
+				want:  "(a | (b ^ (c & (d << (1 + (e % 2))))))",
+			},
+			"Bitwise above comparisons": {
+				input: "x & 1 == 0",
+				want:  "((x & 1) == 0)",
+			},
 			"Groups take priority": {
 				input: "-((5 + 5) * 5)",
 				want:  "(-((5 + 5) * 5))",
@@ -214,6 +282,10 @@ return 9320812;`))
 				input: "add(a, b[1], 1, 2, b[3 * 4], add(1 / [1, 2][1]))",
 				want:  "add(a, (b[1]), 1, 2, (b[(3 * 4)]), add((1 / ([1, 2][1]))))",
 			},
+			"Selectors": {
+				input: "-a.b.c(1)[2] * d.e",
+				want:  "((-(((a[b])[c])(1)[2])) * (d[e]))",
+			},
 		} {
 			tc := tc
 			t.Run(name, func(t *testing.T) {
@@ -285,6 +357,28 @@ return 9320812;`))
 			})
 		}
 	})
+	t.Run("Macro Literal", func(t *testing.T) {
+		t.Parallel()
+		p := parser.New(lexer.New("macro(x, y) { x + y; }"))
+		program := p.Parse()
+		checkErrors(t, p.Errors())
+		is.Equal(t, 1, len(program.Statements))
+		m := program.Statements[0].(ast.ExprStmt).Expression().(ast.MacroLiteral)
+		is.Equal(t, 2, len(m.Params))
+		is.Equal(t, "x", m.Params[0].String())
+		is.Equal(t, "y", m.Params[1].String())
+		is.Equal(t, "(x + y)", m.Body.String())
+		is.Equal(t, "macro(x, y) (x + y)", m.String())
+	})
+	t.Run("Import", func(t *testing.T) {
+		t.Parallel()
+		p := parser.New(lexer.New(`let m = import "lib/m.mmm";`))
+		program := p.Parse()
+		checkErrors(t, p.Errors())
+		imp := program.Statements[0].(ast.LetStmt).Value().(ast.Import)
+		is.Equal(t, "lib/m.mmm", imp.Path())
+		is.Equal(t, `import "lib/m.mmm"`, imp.String())
+	})
 	t.Run("Call Expression", func(t *testing.T) {
 		t.Parallel()
 		p := parser.New(lexer.New("add(1, 2 * 3, 4 + 5);"))
@@ -318,6 +412,258 @@ return 9320812;`))
 		slice := program.Statements[0].(ast.ExprStmt).Expression().(ast.Slice)
 		is.Equal(t, 3, len(slice.Values()))
 	})
+	t.Run("Hash", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+			pairs int
+		}{
+			"Empty":       {input: "{}", want: "{}", pairs: 0},
+			"String keys": {input: `{"one": 1, "two": 2}`, want: "{one: 1, two: 2}", pairs: 2},
+			"Mixed keys":  {input: `{"name": "x", 1: true}`, want: "{name: x, 1: true}", pairs: 2},
+			"Expressions": {input: `{"a" + "b": 1 * 2, 3: [4]}`, want: "{(a + b): (1 * 2), 3: [4]}", pairs: 2},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				p := parser.New(lexer.New(tc.input))
+				program := p.Parse()
+				checkErrors(t, p.Errors())
+				is.Equal(t, 1, len(program.Statements))
+				hash := program.Statements[0].(ast.ExprStmt).Expression().(ast.Hash)
+				is.Equal(t, tc.pairs, len(hash.Pairs()))
+				is.Equal(t, tc.want, hash.String())
+			})
+		}
+	})
+}
+
+func TestParser_Positions(t *testing.T) {
+	t.Parallel()
+	t.Run("Loops", func(t *testing.T) {
+		t.Parallel()
+		p := parser.New(lexer.New(`while (x < 3) { x; }
+outer: for (y in ys) { continue outer; break; }`))
+		program := p.Parse()
+		checkErrors(t, p.Errors())
+		is.Equal(t, 2, len(program.Statements))
+		w := program.Statements[0].(ast.WhileStmt)
+		is.Equal(t, "(x < 3)", w.Cond.String())
+		is.Equal(t, "x", w.Body.String())
+		f := program.Statements[1].(ast.ForStmt)
+		is.Equal(t, "outer", f.Label)
+		is.Equal(t, "y", f.Var.String())
+		is.Equal(t, "ys", f.Iter.String())
+		is.Equal(t, 2, len(f.Body.Statements))
+		cont := f.Body.Statements[0].(ast.BranchStmt)
+		is.Equal(t, token.TypeContinue, cont.Tok())
+		is.Equal(t, "outer", cont.Label)
+		is.Equal(t, "2:24", cont.Pos().String())
+		is.Equal(t, "2:38", cont.End().String())
+		is.Equal(t, "outer: for (y in ys) continue outer;break;", f.String())
+	})
+	t.Run("Assignments", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input  string
+			op     string
+			target string
+			value  string
+		}{
+			"Ident":    {input: "x = 1 + 2;", op: "=", target: "x", value: "(1 + 2)"},
+			"Index":    {input: `m["a"] = 1`, op: "=", target: "(m[a])", value: "1"},
+			"Compound": {input: "xs[0] /= y", op: "/=", target: "(xs[0])", value: "y"},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				p := parser.New(lexer.New(tc.input))
+				program := p.Parse()
+				checkErrors(t, p.Errors())
+				is.Equal(t, 1, len(program.Statements))
+				a := program.Statements[0].(ast.AssignStmt)
+				is.Equal(t, tc.op, a.Operator())
+				is.Equal(t, tc.target, a.Target.String())
+				is.Equal(t, tc.value, a.Value.String())
+			})
+		}
+	})
+	t.Run("Comments", func(t *testing.T) {
+		t.Parallel()
+		for name, mode := range map[string]lexer.Mode{
+			"Skipped": 0,
+			"Scanned": lexer.ScanComments,
+		} {
+			mode := mode
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				l := lexer.New("// add\nlet x = 1 /* one */ + 2; // three\nx")
+				l.SetMode(mode)
+				p := parser.New(l)
+				program := p.Parse()
+				checkErrors(t, p.Errors())
+				is.Equal(t, "let x = (1 + 2);x", program.String())
+			})
+		}
+	})
+	t.Run("Node spans", func(t *testing.T) {
+		t.Parallel()
+		p := parser.New(lexer.New("let add = fn(x, y) {\n\treturn x + y;\n};\nadd(1, [2][0]);"))
+		program := p.Parse()
+		checkErrors(t, p.Errors())
+		is.Equal(t, 2, len(program.Statements))
+
+		let := program.Statements[0].(ast.LetStmt)
+		is.Equal(t, "1:1", let.Pos().String())
+		is.Equal(t, "3:2", let.End().String())
+		fn := let.Value().(ast.Function)
+		is.Equal(t, "1:11", fn.Pos().String())
+		ret := fn.Body.Statements[0].(ast.RetStmt)
+		is.Equal(t, "2:2", ret.Pos().String())
+		is.Equal(t, "2:14", ret.End().String())
+
+		call := program.Statements[1].(ast.ExprStmt).Expression().(ast.CallExpr)
+		is.Equal(t, "4:1", call.Pos().String())
+		is.Equal(t, "4:15", call.End().String())
+		idx := call.Args[1].(ast.Index)
+		is.Equal(t, "4:8", idx.Pos().String())
+		is.Equal(t, "4:14", idx.End().String())
+		is.Equal(t, "4:15", program.End().String())
+	})
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+		for name, tc := range map[string]struct {
+			input string
+			want  string
+		}{
+			"Missing ident": {
+				input: "let = 5;",
+				want:  "main.mmm:1:5: expected next token to be Ident, got Assign",
+			},
+			"No prefix": {
+				input: "let x = 1;\nlet y = );",
+				want:  "main.mmm:2:9: no prefix parse function for RParen found",
+			},
+			"Unterminated string": {
+				input: "let s = \"abc;",
+				want:  "main.mmm:1:9: unterminated string",
+			},
+			"Bad escape": {
+				input: `puts("a\qb");`,
+				want:  `main.mmm:1:8: unknown escape sequence \q`,
+			},
+			"Bad number": {
+				input: "let x = 0xZZ;",
+				want:  `main.mmm:1:9: invalid integer literal "0xZZ"`,
+			},
+			"Illegal character": {
+				input: "let x = 1 @ 2;",
+				want:  "main.mmm:1:11: unexpected character '@'",
+			},
+			"Import without path": {
+				input: "let m = import lib;",
+				want:  "main.mmm:1:16: expected next token to be String, got Ident",
+			},
+			"Selector without name": {
+				input: "m.1",
+				want:  "main.mmm:1:3: expected next token to be Ident, got Int",
+			},
+		} {
+			tc := tc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				p := parser.New(lexer.NewFile("main.mmm", tc.input))
+				p.Parse()
+				is.Equal(t, tc.want, p.Errors()[0])
+			})
+		}
+	})
+}
+
+func TestParser_Recovery(t *testing.T) {
+	t.Parallel()
+	for name, tc := range map[string]struct {
+		input string
+		errs  []string
+		want  string
+	}{
+		"One error per statement": {
+			input: "let = 5; let x = (1 + 2; x",
+			errs: []string{
+				"1:5: expected next token to be Ident, got Assign",
+				"1:24: expected next token to be RParen, got Semicolon",
+			},
+			want: "x",
+		},
+		"Statement keyword": {
+			input: "let x 5\nlet y = 2;\ny",
+			errs:  []string{"1:7: expected next token to be Assign, got Int"},
+			want:  "let y = 2;y",
+		},
+		"Inside block": {
+			input: "let f = fn() { let = 1; 2 };\nf()",
+			errs:  []string{"1:20: expected next token to be Ident, got Assign"},
+			want:  "let f = fn() 2;f()",
+		},
+		"Before closing brace": {
+			input: "if (true) { 1 + }; 3",
+			errs:  []string{"1:17: no prefix parse function for RBrace found"},
+			want:  "iftrue 3",
+		},
+		"Skips nested braces": {
+			input: "if (x { let a = 1; }\n3",
+			errs:  []string{"1:7: expected next token to be RParen, got LBrace"},
+			want:  "3",
+		},
+		"Semicolon after braces": {
+			input: "let z = fn(a, { a };\nz",
+			errs:  []string{"1:17: expected next token to be RParen, got Ident"},
+			want:  "z",
+		},
+		"Break outside loop": {
+			input: "break; 1",
+			errs:  []string{"1:1: break is not in a loop"},
+			want:  "1",
+		},
+		"Undefined label": {
+			input: "for (x in xs) { continue outer; }",
+			errs:  []string{"1:17: continue to undefined label outer"},
+			want:  "for (x in xs) ",
+		},
+		"Break in function": {
+			input: "while (true) { fn() { break; } }",
+			errs:  []string{"1:23: break is not in a loop"},
+			want:  "whiletrue fn() ",
+		},
+		"Label without loop": {
+			input: "a: 1; 2",
+			errs:  []string{"1:1: label a must be followed by a loop, got Int"},
+			want:  "2",
+		},
+		"Assign to call": {
+			input: "f() = 1; 2",
+			errs:  []string{"1:1: cannot assign to f()"},
+			want:  "2",
+		},
+		"Stray brace": {
+			input: "}; 1; ) 2",
+			errs: []string{
+				"1:1: no prefix parse function for RBrace found",
+				"1:7: no prefix parse function for RParen found",
+			},
+			want: "1",
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p := parser.New(lexer.New(tc.input))
+			program := p.Parse()
+			is.Equal(t, strings.Join(tc.errs, "\n"), strings.Join(p.Errors(), "\n"))
+			is.Equal(t, tc.want, program.String())
+		})
+	}
 }
 
 func checkErrors(t *testing.T, errors []string) {
@@ -0,0 +1,116 @@
+package repl
+
+import (
+	"errors"
+
+	"mmm/ast"
+	"mmm/compiler"
+	"mmm/entity"
+	"mmm/eval"
+	"mmm/module"
+	"mmm/vm"
+)
+
+// engine runs the programs entered in a REPL session, keeping the globals
+// between them.
+type engine interface {
+	// run executes prg and returns its value, which is an [entity.Error] when
+	// it failed, or nil when there is nothing to show.
+	run(prg ast.Program) entity.E
+	// bindings are the global names defined so far.
+	bindings() []binding
+	// reset forgets everything defined so far.
+	reset()
+}
+
+type binding struct {
+	name  string
+	value entity.E
+}
+
+// evalEngine walks the AST with the eval package.
+type evalEngine struct {
+	env      entity.Env
+	loader   module.Loader
+	host     *entity.Host
+	maxDepth int
+}
+
+func newEvalEngine(opts Options) *evalEngine {
+	e := &evalEngine{loader: opts.Loader, host: opts.Host, maxDepth: opts.MaxDepth}
+	e.reset()
+	return e
+}
+
+func (e *evalEngine) run(prg ast.Program) entity.E {
+	return eval.EvalWith(prg, e.env, eval.Options{MaxDepth: e.maxDepth})
+}
+
+func (e *evalEngine) bindings() []binding {
+	var bs []binding
+	for _, n := range e.env.Names() {
+		v, _ := e.env.Get(n)
+		bs = append(bs, binding{name: n, value: v})
+	}
+	return bs
+}
+
+func (e *evalEngine) reset() {
+	e.env = entity.NewEnv().WithImporter(eval.NewImporter(e.loader, "")).WithHost(e.host)
+}
+
+// vmEngine compiles to bytecode and runs it on the VM.
+type vmEngine struct {
+	symbols   *compiler.SymbolTable
+	constants []entity.E
+	globals   []entity.E
+	loader    module.Loader
+	host      *entity.Host
+	maxDepth  int
+}
+
+func newVMEngine(opts Options) *vmEngine {
+	e := &vmEngine{loader: opts.Loader, host: opts.Host, maxDepth: opts.MaxDepth}
+	e.reset()
+	return e
+}
+
+func (e *vmEngine) run(prg ast.Program) entity.E {
+	// The symbols are only kept once prg compiles, otherwise the names it
+	// defined would be left without a value.
+	symbols := e.symbols.Copy()
+	c := compiler.NewWithState(symbols, e.constants)
+	c.SetLoader(e.loader, "")
+	if err := c.Compile(prg); err != nil {
+		return entity.Errorf("%s", err)
+	}
+	bc := c.Bytecode()
+	e.symbols, e.constants = symbols, bc.Constants
+	m := vm.NewWithGlobals(bc, e.globals)
+	m.SetMaxDepth(e.maxDepth)
+	if err := m.Run(); err != nil {
+		var ent entity.Error
+		if errors.As(err, &ent) {
+			return ent
+		}
+		return entity.Errorf("%s", err)
+	}
+	return m.Result()
+}
+
+func (e *vmEngine) bindings() []binding {
+	var bs []binding
+	for _, s := range e.symbols.Symbols() {
+		if s.Scope == compiler.ScopeGlobal && e.globals[s.Index] != nil {
+			bs = append(bs, binding{name: s.Name, value: e.globals[s.Index]})
+		}
+	}
+	return bs
+}
+
+func (e *vmEngine) reset() {
+	c := compiler.New()
+	c.SetHost(e.host)
+	e.symbols, e.constants = c.SymbolTable(), c.Bytecode().Constants
+	e.globals = make([]entity.E, vm.GlobalsSize)
+}
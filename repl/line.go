@@ -0,0 +1,266 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// errInterrupt is returned by a lineReader when the user pressed ctrl-c.
+var errInterrupt = errors.New("interrupted")
+
+// lineReader reads a single line of input after showing prompt.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// newLineReader returns a lineEditor when in is a terminal and a plainReader
+// otherwise, e.g. when input is piped in.
+func newLineReader(in io.Reader, out io.Writer, h *history) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(f.Fd()) {
+		return &lineEditor{in: f, r: bufio.NewReader(f), out: out, history: h}
+	}
+	return plainReader{s: bufio.NewScanner(in), out: out}
+}
+
+// plainReader reads lines without any editing.
+type plainReader struct {
+	s   *bufio.Scanner
+	out io.Writer
+}
+
+func (p plainReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	if !p.s.Scan() {
+		if err := p.s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return p.s.Text(), nil
+}
+
+// history is the lines previously entered in the REPL. When file is set every
+// line is also appended to it so it survives between sessions.
+type history struct {
+	lines []string
+	file  string
+}
+
+// maxHistory is the most lines kept in memory and loaded from the file.
+const maxHistory = 1000
+
+func newHistory(file string) *history {
+	h := &history{file: file}
+	if file == "" {
+		return h
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return h
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if l != "" {
+			h.lines = append(h.lines, l)
+		}
+	}
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+	}
+	return h
+}
+
+// add records line, skipping blanks and repeats of the previous line.
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" ||
+		len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[1:]
+	}
+	if h.file == "" {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// lineEditor reads a line from a terminal in raw mode supporting cursor
+// movement, deletion and walking through the history with the arrow keys.
+type lineEditor struct {
+	in      *os.File
+	r       *bufio.Reader
+	out     io.Writer
+	history *history
+}
+
+// Key codes understood by the lineEditor.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.in.Fd())
+	if err != nil {
+		return plainReader{s: bufio.NewScanner(e.r), out: e.out}.readLine(prompt)
+	}
+	defer restore()
+
+	var (
+		buf []rune
+		// pos is the cursor position in buf.
+		pos int
+		// hist is the history entry being shown, len(lines) is the new line.
+		hist = len(e.history.lines)
+		// draft is what was typed before walking through the history.
+		draft []rune
+	)
+	refresh := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	showHistory := func(i int) {
+		if i < 0 || i > len(e.history.lines) {
+			return
+		}
+		if hist == len(e.history.lines) {
+			draft = buf
+		}
+		hist = i
+		if i == len(e.history.lines) {
+			buf = draft
+		} else {
+			buf = []rune(e.history.lines[i])
+		}
+		pos = len(buf)
+	}
+	refresh()
+	for {
+		r, _, err := e.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case keyEnter, '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case keyCtrlD:
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case keyBackspace, keyCtrlH:
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(buf)
+		case keyCtrlB:
+			pos = max(pos-1, 0)
+		case keyCtrlF:
+			pos = min(pos+1, len(buf))
+		case keyCtrlK:
+			buf = buf[:pos]
+		case keyCtrlU:
+			buf = append([]rune{}, buf[pos:]...)
+			pos = 0
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case keyCtrlP:
+			showHistory(hist - 1)
+		case keyCtrlN:
+			showHistory(hist + 1)
+		case keyEscape:
+			switch e.readEscape() {
+			case 'A':
+				showHistory(hist - 1)
+			case 'B':
+				showHistory(hist + 1)
+			case 'C':
+				pos = min(pos+1, len(buf))
+			case 'D':
+				pos = max(pos-1, 0)
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(buf)
+			case '~':
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r == '\t' || unicode.IsPrint(r) {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		refresh()
+	}
+}
+
+// readEscape consumes an ANSI escape sequence and returns its final byte. Only
+// the delete key, ESC [ 3 ~, is reported as '~'.
+func (e *lineEditor) readEscape() byte {
+	b, err := e.r.ReadByte()
+	if err != nil || b != '[' && b != 'O' {
+		return 0
+	}
+	var params []byte
+	for {
+		b, err = e.r.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if b >= '@' && b <= '~' {
+			break
+		}
+		params = append(params, b)
+	}
+	switch {
+	case b == '~' && string(params) == "3":
+		return '~'
+	case b == '~' && (string(params) == "1" || string(params) == "7"):
+		return 'H'
+	case b == '~' && (string(params) == "4" || string(params) == "8"):
+		return 'F'
+	case b == '~':
+		return 0
+	}
+	return b
+}
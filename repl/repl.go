@@ -2,43 +2,251 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"mmm/entity"
 	"mmm/eval"
 	"mmm/lexer"
+	"mmm/module"
 	"mmm/parser"
 	"mmm/token"
+	"os"
+	"sort"
+	"strings"
 )
 
-const prompt = ">> "
+const (
+	prompt = ">> "
+	// contPrompt is shown while reading the rest of incomplete input.
+	contPrompt = ".. "
+)
+
+var (
+	// ErrParse is returned when the input couldn't be parsed.
+	ErrParse = errors.New("input has parse errors")
+	// ErrIllegal is returned when the input has illegal tokens.
+	ErrIllegal = errors.New("input has illegal tokens")
+)
+
+const help = `Enter mmm code to run it, input continues on the next line until every
+bracket and string is closed. Commands:
+	:load file.mmm  run a file in this session
+	:env            show the global bindings
+	:reset          forget every binding
+	:help           show this message
+	:quit           leave the REPL
+`
 
+// Options configure a REPL session.
+type Options struct {
+	// VM compiles input to bytecode and runs it on the VM instead of walking
+	// the AST.
+	VM bool
+	// HistoryFile is where entered lines are saved between sessions. No history
+	// is saved when it's empty.
+	HistoryFile string
+	// Loader is where imported modules come from, the working directory when
+	// it's nil.
+	Loader module.Loader
+	// Host are the Go functions and constants available to the session on top
+	// of the builtins.
+	Host *entity.Host
+	// MaxDepth is how deeply calls can nest before the input fails, there's no
+	// limit when it's 0.
+	MaxDepth int
+}
+
+// Start runs a REPL session walking the AST.
 func Start(in io.Reader, out io.Writer) {
-	s := bufio.NewScanner(in)
-	env := entity.NewEnv()
-	for s.Scan() {
-		fmt.Fprint(out, prompt)
-		p := parser.New(lexer.New((s.Text())))
-		prg := p.Parse()
-		if len(p.Errors()) != 0 {
-			for _, e := range p.Errors() {
-				fmt.Fprint(out, "\t"+e+"\n")
+	Run(in, out, Options{})
+}
+
+// StartVM is like Start, but compiles every input to bytecode and runs it on
+// the VM instead of walking the AST.
+func StartVM(in io.Reader, out io.Writer) {
+	Run(in, out, Options{VM: true})
+}
+
+// Run reads mmm code from in, runs it and writes the result to out until in
+// is exhausted or the user quits.
+func Run(in io.Reader, out io.Writer, opts Options) error {
+	s := &session{
+		out:     out,
+		history: newHistory(opts.HistoryFile),
+		macros:  entity.NewEnv(),
+	}
+	if opts.Loader == nil {
+		opts.Loader = module.FSLoader{FS: os.DirFS(".")}
+	}
+	if opts.VM {
+		s.engine = newVMEngine(opts)
+	} else {
+		s.engine = newEvalEngine(opts)
+	}
+	lr := newLineReader(in, out, s.history)
+	for {
+		src, err := s.read(lr)
+		switch {
+		case errors.Is(err, errInterrupt):
+			continue
+		case errors.Is(err, io.EOF) && src == "":
+			return nil
+		case err != nil && !errors.Is(err, io.EOF):
+			return err
+		}
+		if strings.HasPrefix(strings.TrimSpace(src), ":") {
+			if quit := s.command(strings.TrimSpace(src)); quit {
+				return nil
 			}
 			continue
 		}
-		if e := eval.Eval(prg, env); e != nil {
-			fmt.Fprintf(out, "%+v\n", e.Inspect())
+		s.run(lexer.New(src))
+		if err != nil {
+			return nil
 		}
 	}
 }
 
-func StartParser(in io.Reader, out io.Writer) {
+// session is the state of a single REPL.
+type session struct {
+	out     io.Writer
+	engine  engine
+	history *history
+	// macros are the macros defined so far, they're expanded before the
+	// engine sees the program.
+	macros entity.Env
+}
+
+// read reads lines until they make up complete input.
+func (s *session) read(lr lineReader) (string, error) {
+	var src strings.Builder
+	p := prompt
+	for {
+		line, err := lr.readLine(p)
+		if err != nil {
+			return src.String(), err
+		}
+		s.history.add(line)
+		src.WriteString(line)
+		if strings.HasPrefix(strings.TrimSpace(src.String()), ":") ||
+			complete(src.String()) {
+			return src.String(), nil
+		}
+		src.WriteString("\n")
+		p = contPrompt
+	}
+}
+
+// run parses and runs the input of l, printing the result.
+func (s *session) run(l *lexer.Lexer) {
+	p := parser.New(l)
+	prg := p.Parse()
+	if len(p.Errors()) != 0 {
+		for _, e := range p.Errors() {
+			fmt.Fprint(s.out, "\t"+e+"\n")
+		}
+		return
+	}
+	prg, err := eval.ExpandMacros(eval.DefineMacros(prg, s.macros), s.macros)
+	if err != nil {
+		fmt.Fprintf(s.out, "ERROR: %s\n", err.(entity.Error).Traceback())
+		return
+	}
+	switch e := s.engine.run(prg).(type) {
+	case nil:
+	case entity.Error:
+		fmt.Fprintf(s.out, "ERROR: %s\n", e.Traceback())
+	default:
+		fmt.Fprintf(s.out, "%+v\n", e.Inspect())
+	}
+}
+
+// command runs a meta-command like :load, it reports whether the session
+// should end.
+func (s *session) command(line string) bool {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(s.out, "usage: :load file.mmm")
+			break
+		}
+		src, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintf(s.out, "ERROR: %s\n", err)
+			break
+		}
+		s.run(lexer.NewFile(arg, string(src)))
+	case ":env":
+		bs := s.engine.bindings()
+		sort.Slice(bs, func(i, j int) bool { return bs[i].name < bs[j].name })
+		for _, b := range bs {
+			fmt.Fprintf(s.out, "%s = %s\n", b.name, b.value.Inspect())
+		}
+	case ":reset":
+		s.engine.reset()
+		s.macros = entity.NewEnv()
+		fmt.Fprintln(s.out, "environment reset")
+	case ":help":
+		fmt.Fprint(s.out, help)
+	case ":quit", ":q":
+		return true
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, try :help\n", cmd)
+	}
+	return false
+}
+
+// complete reports whether src can be parsed as is, i.e. every bracket,
+// string and block comment has been closed. Input with more closing than
+// opening brackets is complete, it's up to the parser to report it.
+func complete(src string) bool {
+	var depth int
+	// quote is the char that closes the string being read, 0 outside of one.
+	var quote byte
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '`':
+			quote = c
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return false
+			}
+			i += end + 3
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0 && quote == 0
+}
+
+// StartParser prints the AST of every line read from in. It returns an error
+// if any line failed to parse.
+func StartParser(in io.Reader, out io.Writer) error {
 	s := bufio.NewScanner(in)
+	var failed bool
 	for s.Scan() {
 		fmt.Fprint(out, prompt)
 		p := parser.New(lexer.New((s.Text())))
 		prg := p.Parse()
 		if len(p.Errors()) != 0 {
+			failed = true
 			for _, e := range p.Errors() {
 				fmt.Fprint(out, "\t"+e+"\n")
 			}
@@ -46,15 +254,27 @@ func StartParser(in io.Reader, out io.Writer) {
 		}
 		fmt.Fprintf(out, "%+v\n", prg.String())
 	}
+	if failed {
+		return ErrParse
+	}
+	return s.Err()
 }
 
-func StartLexer(in io.Reader, out io.Writer) {
+// StartLexer prints the tokens of every line read from in. It returns an
+// error if any line had an illegal token.
+func StartLexer(in io.Reader, out io.Writer) error {
 	s := bufio.NewScanner(in)
+	var failed bool
 	for s.Scan() {
 		fmt.Fprint(out, prompt)
 		l := lexer.New(s.Text())
 		for t := l.NextToken(); t.Type() != token.TypeEOF; t = l.NextToken() {
+			failed = failed || t.Type() == token.TypeIllegal
 			fmt.Fprintf(out, "%+v\n", t)
 		}
 	}
+	if failed {
+		return ErrIllegal
+	}
+	return s.Err()
 }
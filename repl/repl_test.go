@@ -0,0 +1,188 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"mmm/entity"
+	"mmm/is"
+	"mmm/module"
+)
+
+func TestComplete(t *testing.T) {
+	t.Parallel()
+	for name, tc := range map[string]struct {
+		input string
+		want  bool
+	}{
+		"Expression":       {input: "1 + 2", want: true},
+		"Open brace":       {input: "let f = fn(x) {", want: false},
+		"Closed brace":     {input: "let f = fn(x) {\n x\n};", want: true},
+		"Open paren":       {input: "add(1,", want: false},
+		"Open bracket":     {input: "[1, 2", want: false},
+		"Open string":      {input: `"hey`, want: false},
+		"Brace in string":  {input: `"{"`, want: true},
+		"Too many closing": {input: "1 }", want: true},
+		"Escaped quote":    {input: `"a\"{`, want: false},
+		"Escaped slash":    {input: `"a\\"`, want: true},
+		"Open raw string":  {input: "`a\n", want: false},
+		"Raw string":       {input: "`a\\`", want: true},
+		"Brace in comment": {input: "1 // {", want: true},
+		"Open comment":     {input: "1 /* {", want: false},
+		"Closed comment":   {input: "1 /* { */", want: true},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			is.Equal(t, tc.want, complete(tc.input))
+		})
+	}
+}
+
+// modules are the modules the TestRun sessions can import.
+var modules = fstest.MapFS{
+	"lib.mmm":     {Data: []byte("let double = fn(x) { x * 2 };")},
+	"counter.mmm": {Data: []byte("let _n = 0; let next = fn() { _n += 1; _n };")},
+}
+
+// host is the Host of the sessions run by the tests.
+var host = func() *entity.Host {
+	h := &entity.Host{}
+	h.Const("greeting", entity.String{Value: "hello"})
+	return h
+}()
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	lib := filepath.Join(t.TempDir(), "lib.mmm")
+	if err := os.WriteFile(lib, []byte("let double = fn(x) {\n\tx * 2\n};\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		input string
+		want  string
+		// wantVM is what the VM prints when it differs from want.
+		wantVM string
+	}{
+		"Single line": {
+			input: "1 + 2\n",
+			want:  ">> 3\n>> ",
+		},
+		"Multi-line function": {
+			input: "let add = fn(x, y) {\n\tx + y\n};\nadd(1, 2)\n",
+			want:  ">> .. .. >> 3\n>> ",
+		},
+		"Multi-line string": {
+			input: "\"a\nb\"\n",
+			want:  ">> .. a\nb\n>> ",
+		},
+		"Parse error": {
+			input: "1 +;\n",
+			want:  ">> \t1:4: no prefix parse function for Semicolon found\n>> ",
+		},
+		"Env": {
+			input: "let b = 2; let a = 1;\n:env\n",
+			want:  ">> >> a = 1\nb = 2\n>> ",
+		},
+		"Reset": {
+			input: "let a = 1;\n:reset\na\n",
+			want:  ">> >> environment reset\n>> ERROR: 1:1: identifier not found: a\n>> ",
+		},
+		"Traceback": {
+			input: "let f = fn() { 1 / 0 };\nf()\n",
+			want:  ">> >> ERROR: 1:16: division by zero\n\tin f, called at 1:1\n>> ",
+		},
+		"Compile error": {
+			input:  "let z = fn() { nope = 1 };\nz()\n",
+			want:   ">> >> ERROR: 1:16: assignment to undeclared identifier: nope\n\tin z, called at 1:1\n>> ",
+			wantVM: ">> ERROR: 1:16: assignment to undeclared identifier: nope\n>> ERROR: 1:1: identifier not found: z\n>> ",
+		},
+		"Runtime error": {
+			input: "let z = 1 / 0;\nz + 1\n",
+			want:  ">> ERROR: 1:9: division by zero\n>> ERROR: 1:1: identifier not found: z\n>> ",
+		},
+		"Load": {
+			input: ":load " + lib + "\ndouble(4)\n",
+			want:  ">> >> 8\n>> ",
+		},
+		"Load missing file": {
+			input: ":load nope.mmm\n",
+			want:  ">> ERROR: open nope.mmm: no such file or directory\n>> ",
+		},
+		"Macro": {
+			input: "let twice = macro(x) { quote(unquote(x) + unquote(x)) };\ntwice(2 * 3)\n",
+			want:  ">> >> 12\n>> ",
+		},
+		"Macro reset": {
+			input: "let m = macro() { quote(1) };\n:reset\nm()\n",
+			want:  ">> >> environment reset\n>> ERROR: 1:1: identifier not found: m\n>> ",
+		},
+		"Macro error": {
+			input: "let m = macro() { 1 };\nm()\n",
+			want:  ">> >> ERROR: 1:1: macro must return a Quote, got Int\n\tin m, called at 1:1\n>> ",
+		},
+		"Import": {
+			input: "let lib = import \"lib.mmm\";\nlib.double(4)\n",
+			want:  ">> >> 8\n>> ",
+		},
+		"Import reset": {
+			input: "let lib = import \"counter.mmm\";\nlib.next();\n:reset\nlet lib = import \"counter.mmm\";\nlib.next()\n",
+			want:  ">> >> 1\n>> environment reset\n>> >> 1\n>> ",
+		},
+		"Host": {
+			input: "greeting\nlet greeting = 1;\n:reset\ngreeting\n",
+			want:  ">> hello\n>> >> environment reset\n>> hello\n>> ",
+		},
+		"Quit": {
+			input: ":quit\n1\n",
+			want:  ">> ",
+		},
+		"Unknown command": {
+			input: ":nope\n",
+			want:  ">> unknown command :nope, try :help\n>> ",
+		},
+	} {
+		tc := tc
+		for _, vm := range []bool{false, true} {
+			vm := vm
+			if vm {
+				name += " VM"
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				var out strings.Builder
+				opts := Options{VM: vm, Loader: module.FSLoader{FS: modules}, Host: host}
+				if err := Run(strings.NewReader(tc.input), &out, opts); err != nil {
+					t.Fatal(err)
+				}
+				want := tc.want
+				if vm && tc.wantVM != "" {
+					want = tc.wantVM
+				}
+				is.Equal(t, want, out.String())
+			})
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "history")
+	var out strings.Builder
+	if err := Run(strings.NewReader("let a = 1;\n\nlet a = 1;\na\n"), &out,
+		Options{HistoryFile: file}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is.Equal(t, "let a = 1;\na\n", string(b))
+
+	h := newHistory(file)
+	is.Equal(t, 2, len(h.lines))
+	is.Equal(t, "a", h.lines[1])
+}
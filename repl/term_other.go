@@ -0,0 +1,13 @@
+//go:build !linux
+
+package repl
+
+import "errors"
+
+// makeRaw isn't supported outside of linux, the REPL falls back to reading
+// plain lines.
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
+
+func isTerminal(fd uintptr) bool { return false }
@@ -1,5 +1,7 @@
 package token
 
+import "strconv"
+
 // Type represents the supported tokens of the mmm language.
 type Type uint8
 
@@ -34,6 +36,33 @@ const (
 	TypeString
 	TypeLBrakt
 	TypeRBrakt
+	TypeColon
+	TypeWhile
+	TypeFor
+	TypeIn
+	TypeBreak
+	TypeContinue
+	TypePlusAssign
+	TypeMinusAssign
+	TypeStarAssign
+	TypeSlashAssign
+	TypeFloat
+	TypeLTEQ
+	TypeGTEQ
+	TypeAnd
+	TypeOr
+	TypePercent
+	TypeAmp
+	TypePipe
+	TypeCaret
+	TypeShl
+	TypeShr
+	// TypeComment is only produced by a [lexer.Lexer] that's been asked to keep
+	// comments.
+	TypeComment
+	TypeMacro
+	TypeImport
+	TypeDot
 
 	// TypeLookup isn't an actual type but a convenience for the [lexer.Lexer] to
 	// pass in a literal value to get a correct [Token].
@@ -74,6 +103,61 @@ var types = [...]string{
 	"String",
 	"LBrakt",
 	"RBrakt",
+	"Colon",
+	"While",
+	"For",
+	"In",
+	"Break",
+	"Continue",
+	"PlusAssign",
+	"MinusAssign",
+	"StarAssign",
+	"SlashAssign",
+	"Float",
+	"LTEQ",
+	"GTEQ",
+	"And",
+	"Or",
+	"Percent",
+	"Amp",
+	"Pipe",
+	"Caret",
+	"Shl",
+	"Shr",
+	"Comment",
+	"Macro",
+	"Import",
+	"Dot",
+}
+
+// Pos is a location in mmm source code. Lines and columns start at 1 and a Pos
+// with a Line of 0 is considered unknown.
+type Pos struct {
+	// File is the name of the file the source came from, it may be empty when
+	// the source didn't come from a file e.g. the REPL.
+	File string
+	// Line is the 1-based line number.
+	Line int
+	// Col is the 1-based column number counted in bytes.
+	Col int
+	// Offset is the 0-based byte offset into the source.
+	Offset int
+}
+
+// IsValid reports whether the position is known.
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+// String renders the position as file:line:col, or line:col when there is no
+// file name. An unknown position is rendered as "-".
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	s := strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Col)
+	if p.File != "" {
+		s = p.File + ":" + s
+	}
+	return s
 }
 
 // Token is one of the supported types of the mmm programming language with some
@@ -81,6 +165,9 @@ var types = [...]string{
 type Token struct {
 	typ Type
 	lit string
+	// pos is where the token starts and end is the position directly after the
+	// last character of the token.
+	pos, end Pos
 }
 
 func New(t Type, literal string) Token {
@@ -100,6 +187,20 @@ func New(t Type, literal string) Token {
 			return Token{typ: TypeBool, lit: "true"}
 		case "false":
 			return Token{typ: TypeBool, lit: "false"}
+		case "while":
+			return Token{typ: TypeWhile, lit: "while"}
+		case "for":
+			return Token{typ: TypeFor, lit: "for"}
+		case "in":
+			return Token{typ: TypeIn, lit: "in"}
+		case "break":
+			return Token{typ: TypeBreak, lit: "break"}
+		case "continue":
+			return Token{typ: TypeContinue, lit: "continue"}
+		case "macro":
+			return Token{typ: TypeMacro, lit: "macro"}
+		case "import":
+			return Token{typ: TypeImport, lit: "import"}
 		default:
 			t = TypeIdent
 		}
@@ -107,6 +208,15 @@ func New(t Type, literal string) Token {
 	return Token{typ: t, lit: literal}
 }
 
+// At returns a copy of the Token that spans from pos up to, but not including,
+// end.
+func (t Token) At(pos, end Pos) Token {
+	t.pos, t.end = pos, end
+	return t
+}
+
 func (t Token) Type() Type      { return t.typ }
 func (t Token) Literal() string { return t.lit }
+func (t Token) Pos() Pos        { return t.pos }
+func (t Token) End() Pos        { return t.end }
 func (t Token) String() string { return "Type: " + t.typ.String() + "Literal: " + t.lit }
@@ -0,0 +1,25 @@
+package vm
+
+import (
+	"mmm/code"
+	"mmm/entity"
+)
+
+// frame is the state of a single function call.
+type frame struct {
+	cl entity.Closure
+	// ip is the instruction pointer, the index of the instruction currently
+	// being executed in the closure's instructions.
+	ip int
+	// bp is the base pointer, where the stack was before the call. Locals live
+	// directly above it.
+	bp int
+}
+
+func newFrame(cl entity.Closure, bp int) *frame {
+	return &frame{cl: cl, ip: -1, bp: bp}
+}
+
+func (f *frame) instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,619 @@
+// package vm executes the [code.Instructions] produced by the compiler package.
+// It's a stack machine: every instruction pops its operands off of the stack
+// and pushes its result back on to it. Running a program through the VM gives
+// the same [entity.E] as evaluating it with the eval package, except that a
+// function can't refer to a local of the function around it that's only
+// defined after it, which fails with identifier not found.
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"mmm/code"
+	"mmm/compiler"
+	"mmm/entity"
+)
+
+const (
+	// StackSize is how many values the stack has room for to begin with, it
+	// grows as needed up to MaxStackSize.
+	StackSize = 2048
+	// MaxStackSize is the most values the stack can hold at once.
+	MaxStackSize = 1 << 20
+	// GlobalsSize is the most global bindings a program can have, it's limited
+	// by the uint16 operand of OpGetGlobal and OpSetGlobal.
+	GlobalsSize = 65536
+	// MaxFrames is the deepest the VM allows calls to go.
+	MaxFrames = 1 << 16
+)
+
+var (
+	_true  = entity.Bool{Value: true}
+	_false = entity.Bool{Value: false}
+	null   = entity.Null{}
+)
+
+// ErrStackOverflow is returned when a program uses more than MaxStackSize
+// values or MaxFrames calls.
+var ErrStackOverflow = errors.New("stack overflow")
+
+// VM runs a single compiled program.
+type VM struct {
+	constants []entity.E
+	globals   []entity.E
+	// names are the names of the globals, see [compiler.Bytecode].
+	names []string
+
+	stack []entity.E
+	// sp is the stack pointer, it always points to the next free slot so the
+	// top of the stack is at sp-1.
+	sp int
+
+	frames []*frame
+	// maxDepth is how deeply calls can nest, there's no limit but MaxFrames
+	// when it's 0.
+	maxDepth int
+
+	// result is the value the program finished with.
+	result entity.E
+}
+
+// New returns a VM ready to run bc.
+func New(bc compiler.Bytecode) *VM {
+	return NewWithGlobals(bc, make([]entity.E, GlobalsSize))
+}
+
+// NewWithGlobals is like New, but starts with the globals of a previous run,
+// this is how the REPL keeps its globals between lines.
+func NewWithGlobals(bc compiler.Bytecode, globals []entity.E) *VM {
+	main := entity.Closure{Fn: entity.CompiledFn{Instructions: bc.Instructions, Spans: bc.Spans}}
+	return &VM{
+		constants: bc.Constants,
+		globals:   globals,
+		names:     bc.Globals,
+		stack:     make([]entity.E, StackSize),
+		frames:    []*frame{newFrame(main, 0)},
+	}
+}
+
+// SetMaxDepth makes calls nesting more than n deep fail with an [entity.Error]
+// of [entity.KindLimit], like the MaxDepth of the eval package's Options except
+// that calls in tail position count too. There's no limit but MaxFrames when n
+// is 0.
+func (vm *VM) SetMaxDepth(n int) { vm.maxDepth = n }
+
+// Result is the value of the last expression statement the VM ran, or the
+// value returned from the program.
+func (vm *VM) Result() entity.E { return vm.result }
+
+// Run executes the program. If the program fails an [entity.Error] is
+// returned as the error.
+func (vm *VM) Run() error { return vm.RunContext(context.Background()) }
+
+// RunContext is like Run, but stops with an [entity.Error] of
+// [entity.KindCanceled] once ctx is done. ctx is checked every time a loop
+// goes round and a function is called.
+func (vm *VM) RunContext(ctx context.Context) error {
+	done := ctx.Done()
+	for {
+		f := vm.frame()
+		f.ip++
+		ins := f.instructions()
+		if f.ip >= len(ins) {
+			return nil
+		}
+		ip := f.ip
+		op := code.Opcode(ins[ip])
+		var err error
+		switch op {
+		case code.OpConstant:
+			f.ip += 2
+			err = vm.push(vm.constants[code.ReadUint16(ins[ip+1:])])
+		case code.OpPop:
+			vm.result = vm.pop()
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpGreaterThan, code.OpGreaterEqual, code.OpEqual, code.OpNotEqual,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShl, code.OpShr:
+			right, left := vm.pop(), vm.pop()
+			var res entity.E
+			if res, err = binaryOp(op, left, right); err == nil {
+				err = vm.push(res)
+			}
+		case code.OpTrue:
+			err = vm.push(_true)
+		case code.OpFalse:
+			err = vm.push(_false)
+		case code.OpNull:
+			err = vm.push(null)
+		case code.OpBang:
+			err = vm.push(staticBool(!isTruthy(vm.pop())))
+		case code.OpMinus:
+			switch v := vm.pop().(type) {
+			case entity.Int, entity.BigInt:
+				err = vm.push(entity.NegInt(v))
+			case entity.Float:
+				err = vm.push(entity.Float{Value: -v.Value})
+			default:
+				err = entity.NewError(entity.KindType, "unknown operator: -%s", v.Type())
+			}
+		case code.OpJump:
+			f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+			if f.ip < ip {
+				// Jumping back is a loop going round.
+				err = canceled(ctx, done)
+			}
+		case code.OpJumpNotTruthy:
+			f.ip += 2
+			if !isTruthy(vm.pop()) {
+				f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+			}
+		case code.OpSetGlobal:
+			f.ip += 2
+			vm.globals[code.ReadUint16(ins[ip+1:])] = vm.pop()
+		case code.OpGetGlobal:
+			f.ip += 2
+			i := int(code.ReadUint16(ins[ip+1:]))
+			err = vm.pushSet(vm.globals[i], vm.names, i)
+		case code.OpSetLocal:
+			f.ip++
+			set(&vm.stack[f.bp+int(code.ReadUint8(ins[ip+1:]))], vm.pop())
+		case code.OpGetLocal:
+			f.ip++
+			i := int(code.ReadUint8(ins[ip+1:]))
+			err = vm.pushSet(get(vm.stack[f.bp+i]), f.cl.Fn.Locals, i)
+		case code.OpGetBuiltin:
+			f.ip++
+			err = vm.push(entity.Builtins[code.ReadUint8(ins[ip+1:])].Builtin)
+		case code.OpGetFree:
+			f.ip++
+			i := int(code.ReadUint8(ins[ip+1:]))
+			err = vm.pushSet(get(f.cl.Free[i]), f.cl.Fn.Free, i)
+		case code.OpSetFree:
+			f.ip++
+			set(&f.cl.Free[code.ReadUint8(ins[ip+1:])], vm.pop())
+		case code.OpCaptureLocal:
+			f.ip++
+			slot := &vm.stack[f.bp+int(code.ReadUint8(ins[ip+1:]))]
+			if _, ok := (*slot).(*cell); !ok {
+				*slot = &cell{v: *slot}
+			}
+			err = vm.push(*slot)
+		case code.OpCaptureFree:
+			f.ip++
+			err = vm.push(f.cl.Free[code.ReadUint8(ins[ip+1:])])
+		case code.OpCurrentClosure:
+			err = vm.push(f.cl)
+		case code.OpSlice:
+			f.ip += 2
+			n := int(code.ReadUint16(ins[ip+1:]))
+			vals := make([]entity.E, n)
+			copy(vals, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			err = vm.push(entity.Slice{Values: vals})
+		case code.OpHash:
+			f.ip += 2
+			n := int(code.ReadUint16(ins[ip+1:]))
+			var h entity.E
+			h, err = hash(vm.stack[vm.sp-n : vm.sp])
+			vm.sp -= n
+			if err == nil {
+				err = vm.push(h)
+			}
+		case code.OpImport:
+			f.ip += 4
+			if m := vm.globals[code.ReadUint16(ins[ip+1:])]; m != nil {
+				err = vm.push(m)
+				f.ip = int(code.ReadUint16(ins[ip+3:])) - 1
+			}
+		case code.OpModule:
+			f.ip += 4
+			name := vm.constants[code.ReadUint16(ins[ip+1:])].(entity.String)
+			n := int(code.ReadUint16(ins[ip+3:]))
+			exports := make(map[string]entity.E, n/2)
+			for i := vm.sp - n; i < vm.sp; i += 2 {
+				// A local is still nil when the module returned before its let.
+				if v := get(vm.stack[i+1]); v != nil {
+					exports[vm.stack[i].(entity.String).Value] = v
+				}
+			}
+			vm.sp -= n
+			err = vm.push(entity.Module{Name: name.Value, Exports: exports})
+		case code.OpIndex:
+			idx, left := vm.pop(), vm.pop()
+			var res entity.E
+			if res, err = index(left, idx); err == nil {
+				err = vm.push(res)
+			}
+		case code.OpClosure:
+			f.ip += 3
+			fn := vm.constants[code.ReadUint16(ins[ip+1:])]
+			n := int(code.ReadUint8(ins[ip+3:]))
+			free := make([]entity.E, n)
+			copy(free, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			err = vm.push(entity.Closure{Fn: fn.(entity.CompiledFn), Free: free})
+		case code.OpSetIndex:
+			f.ip++
+			val, idx, left := vm.pop(), vm.pop(), vm.pop()
+			if op := code.Opcode(code.ReadUint8(ins[ip+1:])); op != 0 {
+				var cur entity.E
+				if cur, err = index(left, idx); err == nil {
+					val, err = binaryOp(op, cur, val)
+				}
+			}
+			if err == nil {
+				if e := entity.SetIndex(left, idx, val); e != nil {
+					err = e.(entity.Error)
+				}
+			}
+		case code.OpIter:
+			v := vm.pop()
+			elems, ok := entity.Elements(v)
+			if !ok {
+				err = entity.NewError(entity.KindType, "cannot iterate over %s", v.Type())
+				break
+			}
+			if err = vm.push(entity.Slice{Values: elems}); err == nil {
+				err = vm.push(entity.Int{Value: 0})
+			}
+		case code.OpNext:
+			f.ip += 2
+			elems := vm.stack[vm.sp-2].(entity.Slice).Values
+			i := vm.stack[vm.sp-1].(entity.Int).Value
+			if i >= int64(len(elems)) {
+				f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+				break
+			}
+			vm.stack[vm.sp-1] = entity.Int{Value: i + 1}
+			err = vm.push(elems[i])
+		case code.OpDrop:
+			vm.pop()
+		case code.OpCall:
+			f.ip++
+			if err = canceled(ctx, done); err == nil {
+				err = vm.call(int(code.ReadUint8(ins[ip+1:])))
+			}
+		case code.OpReturnValue:
+			v := vm.pop()
+			if len(vm.frames) == 1 {
+				// A return in the main program ends it.
+				vm.result = v
+				return nil
+			}
+			vm.sp = vm.popFrame().bp - 1
+			err = vm.push(v)
+		case code.OpReturn:
+			vm.sp = vm.popFrame().bp - 1
+			err = vm.push(null)
+		default:
+			return fmt.Errorf("unknown opcode %s", op)
+		}
+		if err != nil {
+			return vm.traceback(err, f, ip)
+		}
+	}
+}
+
+// canceled returns the Error to stop with once ctx is done, done being
+// ctx.Done().
+func canceled(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return entity.NewError(entity.KindCanceled, "%s", ctx.Err())
+	default:
+		return nil
+	}
+}
+
+// traceback adds where it happened and the functions that are being called to
+// err when it's an [entity.Error], f being the frame that was running the
+// instruction at ip.
+func (vm *VM) traceback(err error, f *frame, ip int) error {
+	ent, ok := err.(entity.Error)
+	if !ok {
+		return err
+	}
+	if span, ok := f.cl.Fn.Spans.Find(ip); ok && !ent.Pos.IsValid() {
+		ent.Pos, ent.End = span.Pos, span.End
+	}
+	for i := len(vm.frames) - 1; i > 0; i-- {
+		// The frame below is still at the call that made this one.
+		caller := vm.frames[i-1]
+		span, _ := caller.cl.Fn.Spans.Find(caller.ip)
+		ent.Stack = append(ent.Stack, entity.Frame{Fn: vm.frames[i].cl.Fn.Name, Pos: span.Pos})
+	}
+	return ent
+}
+
+// call calls the function sitting below its n arguments on the stack.
+func (vm *VM) call(n int) error {
+	switch fn := vm.stack[vm.sp-1-n].(type) {
+	case entity.Closure:
+		if n != fn.Fn.NumParams {
+			return entity.NewError(entity.KindArity, "wrong number of arguments: want=%d, got=%d",
+				fn.Fn.NumParams, n)
+		}
+		if len(vm.frames) == MaxFrames {
+			return ErrStackOverflow
+		}
+		if vm.maxDepth > 0 && len(vm.frames) > vm.maxDepth {
+			return entity.NewError(entity.KindLimit, "call depth limit exceeded: %d", vm.maxDepth)
+		}
+		f := newFrame(fn, vm.sp-n)
+		if err := vm.grow(f.bp + fn.Fn.NumLocals); err != nil {
+			return err
+		}
+		// The slots of the locals may still have cells in them from an earlier
+		// call, which must not be assigned through.
+		clear(vm.stack[vm.sp : f.bp+fn.Fn.NumLocals])
+		vm.frames = append(vm.frames, f)
+		vm.sp = f.bp + fn.Fn.NumLocals
+		return nil
+	case entity.Builtin:
+		args := make([]entity.E, n)
+		copy(args, vm.stack[vm.sp-n:vm.sp])
+		res := fn.Fn(args...)
+		vm.sp -= n + 1
+		if err, ok := res.(entity.Error); ok {
+			return err
+		}
+		if res == nil {
+			res = null
+		}
+		return vm.push(res)
+	default:
+		return entity.NewError(entity.KindType, "not a function: %s", fn.Type())
+	}
+}
+
+func (vm *VM) frame() *frame { return vm.frames[len(vm.frames)-1] }
+
+func (vm *VM) popFrame() *frame {
+	f := vm.frame()
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	return f
+}
+
+func (vm *VM) push(e entity.E) error {
+	if vm.sp >= len(vm.stack) {
+		if err := vm.grow(vm.sp); err != nil {
+			return err
+		}
+	}
+	vm.stack[vm.sp] = e
+	vm.sp++
+	return nil
+}
+
+// pushSet pushes the value of the binding called names[i], which is an Error
+// when it hasn't been set yet, like a let in a branch that wasn't taken.
+func (vm *VM) pushSet(v entity.E, names []string, i int) error {
+	if v == nil {
+		var name string
+		if i < len(names) {
+			name = names[i]
+		}
+		return entity.NewError(entity.KindUnknownIdent, "identifier not found: %s", name)
+	}
+	return vm.push(v)
+}
+
+// grow makes sure the stack has a slot at sp, doubling it as many times as
+// that takes.
+func (vm *VM) grow(sp int) error {
+	if sp < len(vm.stack) {
+		return nil
+	}
+	if sp >= MaxStackSize {
+		return ErrStackOverflow
+	}
+	n := len(vm.stack)
+	for n <= sp {
+		n *= 2
+	}
+	stack := make([]entity.E, min(n, MaxStackSize))
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+	return nil
+}
+
+func (vm *VM) pop() entity.E {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func binaryOp(op code.Opcode, left, right entity.E) (entity.E, error) {
+	left, right = entity.Promote(left, right)
+	if left.Type() != right.Type() {
+		return nil, entity.NewError(entity.KindType, "type mismatch: %s %s %s",
+			left.Type(), operators[op], right.Type())
+	}
+	switch left := left.(type) {
+	case entity.Int, entity.BigInt:
+		return intOp(op, left, right)
+	case entity.Float:
+		r := right.(entity.Float).Value
+		if (op == code.OpDiv || op == code.OpMod) && r == 0 {
+			return nil, entity.NewError(entity.KindDivByZero, "division by zero")
+		}
+		if v := floatOp(op, left.Value, r); v != nil {
+			return v, nil
+		}
+	case entity.String:
+		if op != code.OpAdd {
+			break
+		}
+		return entity.String{Value: left.Value + right.(entity.String).Value}, nil
+	case entity.Bool, entity.Null:
+		switch op {
+		case code.OpEqual:
+			return staticBool(left == right), nil
+		case code.OpNotEqual:
+			return staticBool(left != right), nil
+		}
+	}
+	return nil, entity.NewError(entity.KindType, "unknown operator: %s %s %s",
+		left.Type(), operators[op], right.Type())
+}
+
+// operators are the mmm operators behind each binary Opcode, used for error
+// messages.
+var operators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpGreaterThan:  ">",
+	code.OpGreaterEqual: ">=",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShl:          "<<",
+	code.OpShr:          ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+}
+
+func intOp(op code.Opcode, l, r entity.E) (entity.E, error) {
+	switch op {
+	case code.OpGreaterThan:
+		return staticBool(entity.CompareInts(l, r) > 0), nil
+	case code.OpGreaterEqual:
+		return staticBool(entity.CompareInts(l, r) >= 0), nil
+	case code.OpEqual:
+		return staticBool(entity.CompareInts(l, r) == 0), nil
+	case code.OpNotEqual:
+		return staticBool(entity.CompareInts(l, r) != 0), nil
+	}
+	v := entity.IntOp(operators[op], l, r)
+	if err, ok := v.(entity.Error); ok {
+		return nil, err
+	}
+	return v, nil
+}
+
+// floatOp is nil for the operators that don't work on Floats.
+func floatOp(op code.Opcode, l, r float64) entity.E {
+	switch op {
+	case code.OpAdd:
+		return entity.Float{Value: l + r}
+	case code.OpSub:
+		return entity.Float{Value: l - r}
+	case code.OpMul:
+		return entity.Float{Value: l * r}
+	case code.OpDiv:
+		return entity.Float{Value: l / r}
+	case code.OpMod:
+		return entity.Float{Value: math.Mod(l, r)}
+	case code.OpGreaterThan:
+		return staticBool(l > r)
+	case code.OpGreaterEqual:
+		return staticBool(l >= r)
+	case code.OpEqual:
+		return staticBool(l == r)
+	case code.OpNotEqual:
+		return staticBool(l != r)
+	default:
+		return nil
+	}
+}
+
+func index(left, idx entity.E) (entity.E, error) {
+	switch left := left.(type) {
+	case entity.Slice:
+		i, ok := idx.(entity.Int)
+		if !ok || i.Value < 0 || i.Value > int64(len(left.Values)-1) {
+			return null, nil
+		}
+		return left.Values[i.Value], nil
+	case entity.String:
+		i, ok := idx.(entity.Int)
+		if !ok {
+			return null, nil
+		}
+		if c, ok := left.Char(i.Value); ok {
+			return c, nil
+		}
+		return null, nil
+	case entity.Hash:
+		k, ok := idx.(entity.Hashable)
+		if !ok {
+			return nil, entity.NewError(entity.KindIndex, "unusable as hash key: %s", idx.Type())
+		}
+		p, ok := left.Pairs[k.HashKey()]
+		if !ok {
+			return null, nil
+		}
+		return p.Value, nil
+	case entity.Module:
+		v := left.Get(idx)
+		if err, ok := v.(entity.Error); ok {
+			return nil, err
+		}
+		return v, nil
+	default:
+		return nil, entity.NewError(entity.KindIndex, "index operator not supported for %s", left.Type())
+	}
+}
+
+// hash builds a Hash out of alternating keys and values.
+func hash(kvs []entity.E) (entity.E, error) {
+	pairs := make(map[entity.HashKey]entity.HashPair, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		k, ok := kvs[i].(entity.Hashable)
+		if !ok {
+			return nil, entity.NewError(entity.KindIndex, "unusable as hash key: %s", kvs[i].Type())
+		}
+		pairs[k.HashKey()] = entity.HashPair{Key: k, Value: kvs[i+1]}
+	}
+	return entity.Hash{Pairs: pairs}, nil
+}
+
+// cell holds a local that has been captured by a closure. The frame the local
+// belongs to and every closure that captured it share the same cell.
+type cell struct {
+	v entity.E
+}
+
+func (c *cell) Type() entity.Type { return c.v.Type() }
+func (c *cell) Inspect() string   { return c.v.Inspect() }
+
+// get is the value in a local or free variable slot.
+func get(slot entity.E) entity.E {
+	if c, ok := slot.(*cell); ok {
+		return c.v
+	}
+	return slot
+}
+
+// set assigns v to a local or free variable slot, through its cell if it has
+// been captured.
+func set(slot *entity.E, v entity.E) {
+	if c, ok := (*slot).(*cell); ok {
+		c.v = v
+		return
+	}
+	*slot = v
+}
+
+func staticBool(isTrue bool) entity.Bool {
+	if isTrue {
+		return _true
+	}
+	return _false
+}
+
+func isTruthy(e entity.E) bool {
+	switch e := e.(type) {
+	case entity.Null:
+		return false
+	case entity.Bool:
+		return e.Value
+	default:
+		return true
+	}
+}